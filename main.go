@@ -2,15 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"regexp"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	unifi "github.com/unpoller/unifi/v5"
@@ -18,93 +30,787 @@ import (
 	"github.com/cldmnky/home-lab-exporter/pkg/collector"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
 type Config struct {
-	ListenAddr    string
-	RedfishTarget string
-	RedfishUser   string
-	RedfishPass   string
-	UniFiURL      string
-	UniFiUser     string
-	UniFiPass     string
-}
-
-func initConfig() *Config {
-	pflag.String("listen", ":9100", "HTTP listen address")
-	pflag.String("redfish.target", "", "Redfish target address")
-	pflag.String("redfish.user", "", "Redfish username")
-	pflag.String("redfish.password", "", "Redfish password")
-	pflag.String("unifi.url", "", "UniFi controller URL")
-	pflag.String("unifi.user", "", "UniFi controller username")
-	pflag.String("unifi.pass", "", "UniFi controller password")
+	ListenAddr         string
+	RedfishTarget      string
+	RedfishUser        string
+	RedfishPass        string
+	RedfishPassFile    string
+	RedfishCredentials []string
+	UniFiURL           string
+	UniFiUser          string
+	UniFiPass          string
+	UniFiPassFile      string
+	UniFiAPIToken      string
+	UniFiHeaders       []string
+	UniFiLoginAttempts int
+	UniFiSites         []string
+	UniFiTimeout       time.Duration
+	UniFiDPI           bool
+	UniFiIDS           bool
+	UniFiMinimalPorts  bool
+	UniFiSiteIDLabels  bool
+	UniFiKeepMissing   bool
+	RedfishEnabled     bool
+	UniFiEnabled       bool
+	WebGzip            bool
+	WebTLSCert         string
+	WebTLSKey          string
+	WebTLSClientCA     string
+	WebAuthUser        string
+	WebAuthPass        string // read from WebAuthPassFile only
+	WebAuthPassFile    string
+	WebPprof           bool
+	WebDebug           bool
+	WebTelemetryPath   string
+	MetricDropLabels   []string
+	MetricNamespace    string
+	LogDebug           bool
+	HostEnabled        bool
+	HostProcPath       string
+	RedfishSkipUnknown bool
+	RedfishStaleAfter  time.Duration
+	RedfishSmoothing   float64
+	RedfishTimeout     time.Duration
+	RedfishMaxRequests int
+	RedfishReuseConns  bool
+	IPMITarget         string
+	IPMIUser           string
+	IPMIPass           string
+	CacheTTL           time.Duration
+	FetchJitter        float64
+
+	// Per-target Redfish credentials parsed from RedfishCredentials
+	redfishAuth map[string]redfishCredentials
+	// Headers parsed from UniFiHeaders
+	unifiHeader http.Header
+}
+
+// redfishCredentials holds the login of a single BMC.
+type redfishCredentials struct {
+	user     string
+	password string
+}
+
+// defineFlags registers the command-line flags on fs.
+func defineFlags(fs *pflag.FlagSet) {
+	fs.String("config", "", "Path to a YAML or TOML config file")
+	fs.String("listen", ":9100", "HTTP listen address")
+	fs.String("redfish.target", "", "Redfish target as host, host:port or URL; HTTPS unless a scheme is given")
+	fs.String("redfish.user", "", "Redfish username")
+	fs.String("redfish.password", "", "Redfish password")
+	fs.String("redfish.password-file", "", "File containing the Redfish password")
+	fs.StringArray("redfish.credentials", nil, "Per-target Redfish credentials as target=user:password (repeatable)")
+	fs.Bool("redfish.skip-unknown-health", false, "Omit Redfish sensors whose health is empty or Unknown")
+	fs.Float64("redfish.smoothing", 0, "Weight of each new Redfish temperature reading in a moving average of the readings (0 to export raw readings)")
+	fs.Duration("redfish.stale-after", 5*time.Minute, "Stop serving Redfish readings after the target has been unreachable this long (0 to keep them)")
+	fs.Duration("redfish.timeout", 10*time.Second, "Abort a Redfish fetch, including login, after this long")
+	fs.Int("redfish.max-concurrent-requests", 3, "Requests each Redfish collector may have in flight to the target at once")
+	fs.Bool("redfish.reuse-connections", true, "Keep connections to the Redfish target open between the requests of a fetch")
+	fs.String("ipmi.target", "", "IPMI BMC address; enables the IPMI collector")
+	fs.String("ipmi.user", "", "IPMI username")
+	fs.String("ipmi.password", "", "IPMI password")
+	fs.String("unifi.url", "", "UniFi controller URL")
+	fs.String("unifi.user", "", "UniFi controller username")
+	fs.String("unifi.pass", "", "UniFi controller password")
+	fs.String("unifi.pass-file", "", "File containing the UniFi controller password")
+	fs.String("unifi.api-token", "", "UniFi API key to use instead of the username and password")
+	fs.StringArray("unifi.header", nil, "Extra HTTP header for UniFi controller requests as Name: value (repeatable)")
+	fs.Int("unifi.login-attempts", 3, "Login attempts per UniFi fetch, with exponential backoff starting at 1s")
+	fs.Duration("unifi.timeout", 10*time.Second, "Abort a UniFi controller request after this long")
+	fs.Bool("unifi.dpi.enabled", false, "Fetch per-client DPI application traffic, one extra request per site")
+	fs.Bool("unifi.ids.enabled", false, "Fetch IDS/IPS threat alarms, one extra request per site")
+	fs.Bool("unifi.port-labels-minimal", false, "Drop the source and uplink labels from port metrics")
+	fs.Bool("unifi.keep-missing-devices", false, "Keep exporting unifi_device_state 0 for devices the controller stopped listing, until the exporter restarts")
+	fs.Bool("unifi.site-id-labels", false, "Label UniFi metrics with the site ID, which survives renaming the site, instead of its name")
+	fs.StringSlice("unifi.sites", nil, "Comma-separated UniFi site names or descriptions to collect (default all)")
+	fs.Duration("cache.ttl", 0, "Stop serving a collector's cached metrics this long after its last successful fetch (0 to keep them)")
+	fs.Float64("collector.fetch-jitter", 0.1, "Randomly vary each collector's 30s fetch interval by up to this fraction (0 to fetch at fixed intervals)")
+	fs.Bool("web.gzip", true, "Offer gzip compression on the metrics endpoint")
+	fs.String("web.tls-cert", "", "TLS certificate file; serves HTTPS together with --web.tls-key")
+	fs.String("web.tls-key", "", "TLS private key file")
+	fs.String("web.tls-client-ca", "", "CA file to verify client certificates against; clients without a valid certificate are rejected")
+	fs.String("web.auth-user", "", "Require HTTP basic auth with this user on /metrics, /probe and /health")
+	fs.String("web.auth-password-file", "", "File containing the basic auth password")
+	fs.Bool("web.pprof", false, "Serve Go profiling data under /debug/pprof/")
+	fs.Bool("web.debug", false, "Serve the effective configuration, passwords redacted, under /config")
+	fs.String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	fs.StringSlice("metric.drop-labels", nil, "Comma-separated labels to remove from every metric under the telemetry path, e.g. mac,source")
+	fs.String("metric.namespace", "", "Prefix prepended to the name of every collector metric, e.g. homelab_")
+	fs.Bool("log.debug", false, "Enable debug logging")
+	fs.Bool("collector.redfish.enabled", true, "Enable the Redfish collectors")
+	fs.Bool("collector.unifi.enabled", true, "Enable the UniFi collector")
+	fs.Bool("collector.host.enabled", false, "Enable the host collector (Linux only)")
+	fs.String("collector.host.procfs", "/proc", "procfs mount point read by the host collector")
+}
+
+func initConfig() (*Config, error) {
+	defineFlags(pflag.CommandLine)
 	pflag.Parse()
+	return loadConfig(pflag.CommandLine)
+}
 
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.BindPFlags(pflag.CommandLine)
+// loadConfig builds the Config from flags, environment variables and the
+// optional --config file, in that order of precedence.
+func loadConfig(fs *pflag.FlagSet) (*Config, error) {
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	if err := v.BindPFlags(fs); err != nil {
+		return nil, err
+	}
+	// The flag is --unifi.pass, the documented variable UNIFI_PASSWORD
+	if err := v.BindEnv("unifi.pass", "UNIFI_PASS", "UNIFI_PASSWORD"); err != nil {
+		return nil, err
+	}
+	if path := v.GetString("config"); path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+	}
 
 	return &Config{
-		ListenAddr:    viper.GetString("listen"),
-		RedfishTarget: viper.GetString("redfish.target"),
-		RedfishUser:   viper.GetString("redfish.user"),
-		RedfishPass:   viper.GetString("redfish.password"),
-		UniFiURL:      viper.GetString("unifi.url"),
-		UniFiUser:     viper.GetString("unifi.user"),
-		UniFiPass:     viper.GetString("unifi.password"),
+		ListenAddr:         v.GetString("listen"),
+		RedfishTarget:      v.GetString("redfish.target"),
+		RedfishUser:        v.GetString("redfish.user"),
+		RedfishPass:        v.GetString("redfish.password"),
+		RedfishPassFile:    v.GetString("redfish.password-file"),
+		RedfishCredentials: v.GetStringSlice("redfish.credentials"),
+		UniFiURL:           v.GetString("unifi.url"),
+		UniFiUser:          v.GetString("unifi.user"),
+		UniFiPass:          v.GetString("unifi.pass"),
+		UniFiPassFile:      v.GetString("unifi.pass-file"),
+		UniFiAPIToken:      v.GetString("unifi.api-token"),
+		UniFiHeaders:       v.GetStringSlice("unifi.header"),
+		UniFiLoginAttempts: v.GetInt("unifi.login-attempts"),
+		UniFiSites:         v.GetStringSlice("unifi.sites"),
+		UniFiTimeout:       v.GetDuration("unifi.timeout"),
+		UniFiDPI:           v.GetBool("unifi.dpi.enabled"),
+		UniFiIDS:           v.GetBool("unifi.ids.enabled"),
+		UniFiMinimalPorts:  v.GetBool("unifi.port-labels-minimal"),
+		UniFiSiteIDLabels:  v.GetBool("unifi.site-id-labels"),
+		UniFiKeepMissing:   v.GetBool("unifi.keep-missing-devices"),
+		RedfishEnabled:     v.GetBool("collector.redfish.enabled"),
+		UniFiEnabled:       v.GetBool("collector.unifi.enabled"),
+		WebGzip:            v.GetBool("web.gzip"),
+		WebTLSCert:         v.GetString("web.tls-cert"),
+		WebTLSKey:          v.GetString("web.tls-key"),
+		WebTLSClientCA:     v.GetString("web.tls-client-ca"),
+		WebAuthUser:        v.GetString("web.auth-user"),
+		WebAuthPassFile:    v.GetString("web.auth-password-file"),
+		WebPprof:           v.GetBool("web.pprof"),
+		WebDebug:           v.GetBool("web.debug"),
+		WebTelemetryPath:   v.GetString("web.telemetry-path"),
+		MetricDropLabels:   v.GetStringSlice("metric.drop-labels"),
+		MetricNamespace:    v.GetString("metric.namespace"),
+		LogDebug:           v.GetBool("log.debug"),
+		HostEnabled:        v.GetBool("collector.host.enabled"),
+		HostProcPath:       v.GetString("collector.host.procfs"),
+		RedfishSkipUnknown: v.GetBool("redfish.skip-unknown-health"),
+		RedfishStaleAfter:  v.GetDuration("redfish.stale-after"),
+		RedfishSmoothing:   v.GetFloat64("redfish.smoothing"),
+		RedfishTimeout:     v.GetDuration("redfish.timeout"),
+		RedfishMaxRequests: v.GetInt("redfish.max-concurrent-requests"),
+		RedfishReuseConns:  v.GetBool("redfish.reuse-connections"),
+		IPMITarget:         v.GetString("ipmi.target"),
+		IPMIUser:           v.GetString("ipmi.user"),
+		IPMIPass:           v.GetString("ipmi.password"),
+		CacheTTL:           v.GetDuration("cache.ttl"),
+		FetchJitter:        v.GetFloat64("collector.fetch-jitter"),
+	}, nil
+}
+
+// metricNamespaceRE matches prefixes that keep metric names valid.
+var metricNamespaceRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// validate checks that every enabled collector has a target.
+func (cfg *Config) validate() error {
+	if !cfg.RedfishEnabled && !cfg.UniFiEnabled && cfg.IPMITarget == "" {
+		return errors.New("at least one of the Redfish, UniFi and IPMI collectors must be enabled")
+	}
+	if cfg.RedfishEnabled && cfg.RedfishTarget == "" {
+		return errors.New("redfish.target is required unless --collector.redfish.enabled=false")
 	}
+	if cfg.RedfishEnabled && cfg.RedfishTimeout <= 0 {
+		return errors.New("redfish.timeout must be positive")
+	}
+	if cfg.RedfishEnabled && cfg.RedfishMaxRequests < 1 {
+		return errors.New("redfish.max-concurrent-requests must be at least 1")
+	}
+	if cfg.RedfishSmoothing < 0 || cfg.RedfishSmoothing > 1 {
+		return errors.New("redfish.smoothing must be between 0 and 1")
+	}
+	if cfg.UniFiEnabled && cfg.UniFiURL == "" {
+		return errors.New("unifi.url is required unless --collector.unifi.enabled=false")
+	}
+	if cfg.UniFiEnabled && cfg.UniFiTimeout <= 0 {
+		return errors.New("unifi.timeout must be positive")
+	}
+	if (cfg.WebTLSCert == "") != (cfg.WebTLSKey == "") {
+		return errors.New("web.tls-cert and web.tls-key must be given together")
+	}
+	if (cfg.WebAuthUser == "") != (cfg.WebAuthPassFile == "") {
+		return errors.New("web.auth-user and web.auth-password-file must be given together")
+	}
+	if cfg.WebTLSClientCA != "" && cfg.WebTLSCert == "" {
+		return errors.New("web.tls-client-ca requires web.tls-cert and web.tls-key")
+	}
+	if cfg.MetricNamespace != "" && !metricNamespaceRE.MatchString(cfg.MetricNamespace) {
+		return errors.New("metric.namespace must only contain letters, digits, _ and : and must not start with a digit")
+	}
+	if cfg.FetchJitter < 0 || cfg.FetchJitter >= 1 {
+		return errors.New("collector.fetch-jitter must be at least 0 and less than 1")
+	}
+	// / is taken by the landing page
+	if !strings.HasPrefix(cfg.WebTelemetryPath, "/") || cfg.WebTelemetryPath == "/" {
+		return errors.New("web.telemetry-path must start with / and must not be /")
+	}
+	return nil
 }
 
-func main() {
-	cfg := initConfig()
+// loadSecrets replaces passwords with the contents of their files, if given,
+// so that they need not appear on the command line.
+func (cfg *Config) loadSecrets() error {
+	for _, s := range []struct {
+		file string
+		dest *string
+	}{
+		{cfg.RedfishPassFile, &cfg.RedfishPass},
+		{cfg.UniFiPassFile, &cfg.UniFiPass},
+		{cfg.WebAuthPassFile, &cfg.WebAuthPass},
+	} {
+		if s.file == "" {
+			continue
+		}
+		b, err := os.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("reading secret: %w", err)
+		}
+		*s.dest = strings.TrimRight(string(b), "\r\n")
+	}
+	return nil
+}
+
+// parseRedfishCredentials parses the target=user:password entries of
+// RedfishCredentials. The password may itself contain colons.
+func (cfg *Config) parseRedfishCredentials() error {
+	cfg.redfishAuth = make(map[string]redfishCredentials, len(cfg.RedfishCredentials))
+	for _, entry := range cfg.RedfishCredentials {
+		target, login, ok := strings.Cut(entry, "=")
+		if !ok || target == "" {
+			return fmt.Errorf("invalid Redfish credentials %q: want target=user:password", entry)
+		}
+		user, password, ok := strings.Cut(login, ":")
+		if !ok {
+			return fmt.Errorf("invalid Redfish credentials for %s: want target=user:password", target)
+		}
+		cfg.redfishAuth[target] = redfishCredentials{user: user, password: password}
+	}
+	return nil
+}
 
-	// Optional: Validate config
-	if cfg.RedfishTarget == "" || cfg.UniFiURL == "" {
-		log.Fatalln("At least one of Redfish and UniFi config must be provided")
+// parseUniFiHeaders parses the Name: value entries of UniFiHeaders.
+func (cfg *Config) parseUniFiHeaders() error {
+	cfg.unifiHeader = make(http.Header, len(cfg.UniFiHeaders))
+	for _, entry := range cfg.UniFiHeaders {
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("invalid UniFi header %q: want Name: value", entry)
+		}
+		cfg.unifiHeader.Add(name, strings.TrimSpace(value))
 	}
+	return nil
+}
 
-	c := unifi.Config{
-		User:     cfg.UniFiUser,
-		Pass:     cfg.UniFiPass,
-		URL:      cfg.UniFiURL,
-		ErrorLog: log.Printf,
-		//DebugLog: log.Printf,
+// redfishLogin returns the credentials for a Redfish target, falling back to
+// the shared redfish.user and redfish.password.
+func (cfg *Config) redfishLogin(target string) (user, password string) {
+	if c, ok := cfg.redfishAuth[target]; ok {
+		return c.user, c.password
 	}
-	client, err := unifi.NewUnifi(&c)
-	if err != nil {
-		log.Fatalln("Error creating UniFi client:", err)
+	return cfg.RedfishUser, cfg.RedfishPass
+}
+
+// redactedSecret replaces a password that is set.
+const redactedSecret = "***"
+
+// redacted returns a copy of the Config with its passwords replaced by
+// redactedSecret. Empty passwords stay empty, so that it shows whether one
+// was picked up at all.
+func (cfg *Config) redacted() Config {
+	r := *cfg
+	for _, p := range []*string{&r.RedfishPass, &r.UniFiPass, &r.UniFiAPIToken, &r.WebAuthPass, &r.IPMIPass} {
+		if *p != "" {
+			*p = redactedSecret
+		}
+	}
+	r.RedfishCredentials = make([]string, len(cfg.RedfishCredentials))
+	for i, entry := range cfg.RedfishCredentials {
+		// Entries that do not parse are hidden entirely, as there is no
+		// telling where their password starts
+		r.RedfishCredentials[i] = redactedSecret
+		if target, login, ok := strings.Cut(entry, "="); ok {
+			if user, _, ok := strings.Cut(login, ":"); ok {
+				r.RedfishCredentials[i] = target + "=" + user + ":" + redactedSecret
+			}
+		}
+	}
+	// Headers often carry tokens, so only their names are shown
+	r.UniFiHeaders = make([]string, len(cfg.UniFiHeaders))
+	for i, entry := range cfg.UniFiHeaders {
+		name, _, _ := strings.Cut(entry, ":")
+		r.UniFiHeaders[i] = name + ": " + redactedSecret
+	}
+	return r
+}
+
+// headerTransport adds fixed headers to every request, replacing headers of
+// the same name.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
 	}
+	return t.base.RoundTrip(req)
+}
+
+// tlsConfig returns the TLS settings of the web server, requiring client
+// certificates signed by WebTLSClientCA if it is set. It returns nil when TLS
+// is off.
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	if cfg.WebTLSCert == "" {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.WebTLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.WebTLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.WebTLSClientCA)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+// newBuildInfo returns the home_lab_exporter_build_info gauge, always 1.
+func newBuildInfo() prometheus.Gauge {
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "home_lab_exporter_build_info",
+		Help: "Version, commit and Go version the exporter was built with",
+		ConstLabels: prometheus.Labels{
+			"version":    version,
+			"commit":     commit,
+			"go_version": runtime.Version(),
+		},
+	})
+	info.Set(1)
+	return info
+}
 
-	thermalCollector := collector.NewThermalCollector(cfg.RedfishTarget, cfg.RedfishUser, cfg.RedfishPass)
-	unifiCollector := collector.NewUniFiCollectorWithClient(client)
-	prometheus.MustRegister(thermalCollector, unifiCollector)
+// metricsHandler returns the /metrics handler. When gzip is enabled the
+// response is compressed for clients sending a matching Accept-Encoding.
+func metricsHandler(reg prometheus.Registerer, g prometheus.Gatherer, gzip bool) http.Handler {
+	opts := promhttp.HandlerOpts{DisableCompression: !gzip}
+	if gzip {
+		opts.OfferedCompressions = []promhttp.Compression{promhttp.Identity, promhttp.Gzip}
+	}
+	return promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(g, opts))
+}
 
-	http.Handle("/metrics", promhttp.Handler())
+// labelDropper removes labels from every metric it gathers, to keep high
+// churn labels such as mac out of the TSDB. Series that only differed in
+// the dropped labels are collapsed into one holding their sum, or into the
+// first of them for histograms and summaries.
+type labelDropper struct {
+	gatherer prometheus.Gatherer
+	drop     map[string]bool
+}
 
-	// Health endpoints
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+// dropLabels returns a Gatherer serving the metrics of g without the given
+// labels. It returns g itself if there are none.
+func dropLabels(g prometheus.Gatherer, labels []string) prometheus.Gatherer {
+	if len(labels) == 0 {
+		return g
+	}
+	drop := map[string]bool{}
+	for _, l := range labels {
+		drop[strings.TrimSpace(l)] = true
+	}
+	return labelDropper{gatherer: g, drop: drop}
+}
+
+func (d labelDropper) Gather() ([]*dto.MetricFamily, error) {
+	// Gather returns fresh families on every call, so they are edited in
+	// place. They may be incomplete along with an error.
+	families, err := d.gatherer.Gather()
+	for _, mf := range families {
+		seen := map[string]*dto.Metric{}
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			var key strings.Builder
+			labels := m.Label[:0]
+			for _, l := range m.Label {
+				if d.drop[l.GetName()] {
+					continue
+				}
+				labels = append(labels, l)
+				key.WriteString(l.GetName() + "\xff" + l.GetValue() + "\xff")
+			}
+			m.Label = labels
+			if first, ok := seen[key.String()]; ok {
+				if !addValue(first, m) {
+					log.Printf("Dropping a %s series that collides with another once labels are dropped", mf.GetName())
+				}
+				continue
+			}
+			seen[key.String()] = m
+			metrics = append(metrics, m)
+		}
+		mf.Metric = metrics
+	}
+	return families, err
+}
+
+// addValue adds the value of m to into. It reports false for histograms and
+// summaries, which cannot be added up.
+func addValue(into, m *dto.Metric) bool {
+	var sum float64
+	switch {
+	case into.Counter != nil:
+		sum = into.Counter.GetValue() + m.Counter.GetValue()
+		into.Counter.Value = &sum
+	case into.Gauge != nil:
+		sum = into.Gauge.GetValue() + m.Gauge.GetValue()
+		into.Gauge.Value = &sum
+	case into.Untyped != nil:
+		sum = into.Untyped.GetValue() + m.Untyped.GetValue()
+		into.Untyped.Value = &sum
+	default:
+		return false
+	}
+	return true
+}
+
+// landingPage links to the metrics path, which the exporter serves under
+// --web.telemetry-path.
+const landingPage = `<html>
+<head><title>Home Lab Exporter</title></head>
+<body>
+<h1>Home Lab Exporter</h1>
+<p><a href="%s">Metrics</a></p>
+</body>
+</html>
+`
+
+// landingHandler serves the landing page at / and 404 for any other path
+// that no other handler claims.
+func landingHandler(metricsPath string) http.Handler {
+	page := fmt.Sprintf(landingPage, html.EscapeString(metricsPath))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+}
+
+// basicAuth wraps next so that it requires HTTP basic auth with the given
+// credentials. An empty user disables the check.
+func basicAuth(next http.Handler, user, password string) http.Handler {
+	if user == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// Compare both in constant time so that neither leaks through timing
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="home-lab-exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pprofHandler serves the runtime profiles under /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// configHandler serves the effective configuration as JSON, with the
+// passwords redacted.
+func configHandler(cfg *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg.redacted()); err != nil {
+			log.Println("Error encoding config:", err)
+		}
+	})
+}
+
+// readyChecker is a collector that knows whether it has data to serve.
+type readyChecker interface {
+	Ready() bool
+}
+
+// readyHandler serves /readyz, failing with 503 until every collector has
+// completed a successful fetch.
+func readyHandler(collectors []readyChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range collectors {
+			if !c.Ready() {
+				http.Error(w, "waiting for the first scrape", http.StatusServiceUnavailable)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
-	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+}
+
+// healthReporter is a collector that summarizes its fetches.
+type healthReporter interface {
+	Health() collector.Health
+}
+
+// healthHandler serves /health: the outcome of the fetches of every
+// collector as JSON. Unlike /healthz and /readyz it always answers 200, as
+// it is meant for operators rather than probes.
+func healthHandler(collectors []healthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := make([]collector.Health, 0, len(collectors))
+		for _, c := range collectors {
+			health = append(health, c.Health())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Collectors []collector.Health `json:"collectors"`
+		}{health}); err != nil {
+			log.Println("Error encoding health:", err)
+		}
+	})
+}
+
+// probeHandler scrapes the target given in the query string once and serves
+// the result, so Prometheus can manage BMC targets through relabeling in the
+// style of blackbox_exporter. Only the "redfish" module is supported. login
+// returns the credentials to use for a target.
+func probeHandler(login func(target string) (user, password string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		module := r.URL.Query().Get("module")
+		if module == "" {
+			module = "redfish"
+		}
+		if module != "redfish" {
+			http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+			return
+		}
+
+		username, password := login(target)
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(collector.ProbeThermal(target, username, password))
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+func main() {
+	cfg, err := initConfig()
+	if err != nil {
+		log.Fatalln("Error loading config:", err)
+	}
+	if err := cfg.loadSecrets(); err != nil {
+		log.Fatalln("Error loading secrets:", err)
+	}
+	if err := cfg.parseRedfishCredentials(); err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
+	if err := cfg.parseUniFiHeaders(); err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
+
+	if cfg.LogDebug {
+		collector.Debugf = log.Printf
+	}
+	collector.RedfishTimeout = cfg.RedfishTimeout
+	collector.RedfishMaxConcurrentRequests = cfg.RedfishMaxRequests
+	collector.RedfishReuseConnections = cfg.RedfishReuseConns
+	collector.CacheTTL = cfg.CacheTTL
+	collector.FetchJitter = cfg.FetchJitter
+	collector.MetricNamespace = cfg.MetricNamespace
+	collector.UniFiLoginAttempts = cfg.UniFiLoginAttempts
+	collector.UniFiSites = cfg.UniFiSites
+	collector.UniFiDPI = cfg.UniFiDPI
+	collector.UniFiIDS = cfg.UniFiIDS
+	collector.UniFiMinimalPortLabels = cfg.UniFiMinimalPorts
+	collector.UniFiSiteIDLabels = cfg.UniFiSiteIDLabels
+	collector.UniFiKeepMissingDevices = cfg.UniFiKeepMissing
+
+	// Background collectors to stop on shutdown and to wait for in /readyz
+	var stoppers []interface{ Stop() }
+	var readiness []readyChecker
+	var health []healthReporter
+	// Logged out once the collectors sharing it have stopped
+	var redfishSession *collector.RedfishSession
+	// Summed into the total power of the home lab
+	var powerSources []collector.PowerSource
+
+	if cfg.RedfishEnabled {
+		user, password := cfg.redfishLogin(cfg.RedfishTarget)
+		redfishSession = collector.NewRedfishSession(cfg.RedfishTarget, user, password)
+		thermalCollector := collector.NewThermalCollector(redfishSession)
+		thermalCollector.SkipUnknownHealth = cfg.RedfishSkipUnknown
+		thermalCollector.StaleAfter = cfg.RedfishStaleAfter
+		thermalCollector.Smoothing = cfg.RedfishSmoothing
+		memoryCollector := collector.NewMemoryCollector(redfishSession)
+		storageCollector := collector.NewStorageCollector(redfishSession)
+		systemCollector := collector.NewSystemCollector(redfishSession)
+		logCollector := collector.NewLogCollector(redfishSession)
+		nicCollector := collector.NewNICCollector(redfishSession)
+		prometheus.MustRegister(thermalCollector, memoryCollector, storageCollector, systemCollector, logCollector, nicCollector)
+		stoppers = append(stoppers, thermalCollector, memoryCollector, storageCollector, systemCollector, logCollector, nicCollector)
+		readiness = append(readiness, thermalCollector, memoryCollector, storageCollector, systemCollector, logCollector, nicCollector)
+		health = append(health, thermalCollector, memoryCollector, storageCollector, systemCollector, logCollector, nicCollector)
+		powerSources = append(powerSources, thermalCollector)
+	}
+	if cfg.UniFiEnabled {
+		c := unifi.Config{
+			User:     cfg.UniFiUser,
+			Pass:     cfg.UniFiPass,
+			APIKey:   cfg.UniFiAPIToken,
+			URL:      cfg.UniFiURL,
+			Timeout:  cfg.UniFiTimeout,
+			ErrorLog: log.Printf,
+			DebugLog: collector.Debugf,
+		}
+		client, err := unifi.NewUnifi(&c)
+		if client != nil && len(cfg.unifiHeader) > 0 {
+			// NewUnifi already tried to log in without the headers, so
+			// log in again with them if that failed
+			client.Client.Transport = &headerTransport{base: client.Client.Transport, header: cfg.unifiHeader}
+			if err != nil {
+				err = client.Login()
+			}
+		}
+		if err != nil {
+			log.Fatalln("Error creating UniFi client:", err)
+		}
+		unifiCollector := collector.NewUniFiCollectorWithClient(client)
+		prometheus.MustRegister(unifiCollector)
+		stoppers = append(stoppers, unifiCollector)
+		readiness = append(readiness, unifiCollector)
+		health = append(health, unifiCollector)
+		powerSources = append(powerSources, unifiCollector)
+	}
+	if cfg.IPMITarget != "" {
+		ipmiCollector := collector.NewIPMICollector(cfg.IPMITarget, cfg.IPMIUser, cfg.IPMIPass)
+		prometheus.MustRegister(ipmiCollector)
+		stoppers = append(stoppers, ipmiCollector)
+		readiness = append(readiness, ipmiCollector)
+		health = append(health, ipmiCollector)
+	}
+	prometheus.MustRegister(collector.CollectorGoroutines, collector.ActiveCollectors, newBuildInfo())
+	if len(powerSources) > 0 {
+		prometheus.MustRegister(collector.NewTotalPowerCollector(powerSources...))
+	}
+	if cfg.HostEnabled {
+		prometheus.MustRegister(collector.NewHostCollector(cfg.HostProcPath))
+	}
+
+	// A dedicated mux, as importing net/http/pprof registers its handlers on
+	// the default one
+	mux := http.NewServeMux()
+	mux.Handle("/", landingHandler(cfg.WebTelemetryPath))
+	mux.Handle(cfg.WebTelemetryPath, basicAuth(metricsHandler(prometheus.DefaultRegisterer, dropLabels(prometheus.DefaultGatherer, cfg.MetricDropLabels), cfg.WebGzip), cfg.WebAuthUser, cfg.WebAuthPass))
+	mux.Handle("/probe", basicAuth(probeHandler(cfg.redfishLogin), cfg.WebAuthUser, cfg.WebAuthPass))
+
+	// Health endpoints stay unauthenticated for kubelet probes
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
+	mux.Handle("/readyz", readyHandler(readiness))
+	mux.Handle("/health", basicAuth(healthHandler(health), cfg.WebAuthUser, cfg.WebAuthPass))
+	if cfg.WebPprof {
+		mux.Handle("/debug/pprof/", basicAuth(pprofHandler(), cfg.WebAuthUser, cfg.WebAuthPass))
+	}
+	if cfg.WebDebug {
+		mux.Handle("/config", basicAuth(configHandler(cfg), cfg.WebAuthUser, cfg.WebAuthPass))
+	}
+
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		log.Fatalln("Invalid TLS configuration:", err)
+	}
 
 	log.Println("Starting exporter on ", cfg.ListenAddr)
 
-	srv := &http.Server{Addr: cfg.ListenAddr}
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: mux, TLSConfig: tlsCfg}
 
 	// Channel to listen for interrupt or terminate signals
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsCfg != nil {
+			err = srv.ListenAndServeTLS(cfg.WebTLSCert, cfg.WebTLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe(): %v", err)
 		}
 	}()
 
 	<-done
 	log.Println("Shutting down gracefully...")
+	for _, s := range stoppers {
+		s.Stop()
+	}
+	if redfishSession != nil {
+		redfishSession.Close()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*1e9) // 5 seconds
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cldmnky/home-lab-exporter/pkg/collector"
+)
+
+func TestMetricsHandlerGzip(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rec := httptest.NewRecorder()
+	metricsHandler(reg, reg, true).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+	rec = httptest.NewRecorder()
+	metricsHandler(reg, reg, false).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+}
+
+func TestDropLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_port_up", Help: "Port state"}, []string{"name", "port", "mac"})
+	reg.MustRegister(g)
+	g.WithLabelValues("usw-1", "1", "aa:aa").Set(1)
+	// Differs from the port above only in the mac
+	g.WithLabelValues("usw-1", "1", "bb:bb").Set(2)
+	g.WithLabelValues("usw-1", "2", "cc:cc").Set(1)
+	// Histograms cannot be added up, so only the first is kept
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_latency_seconds", Help: "Latency", Buckets: []float64{1}}, []string{"port", "mac"})
+	reg.MustRegister(h)
+	h.WithLabelValues("1", "aa:aa").Observe(0.5)
+	h.WithLabelValues("1", "bb:bb").Observe(2)
+
+	assert.Same(t, reg, dropLabels(reg, nil))
+
+	expected := `
+# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{port="1",le="1"} 1
+test_latency_seconds_bucket{port="1",le="+Inf"} 1
+test_latency_seconds_sum{port="1"} 0.5
+test_latency_seconds_count{port="1"} 1
+# HELP test_port_up Port state
+# TYPE test_port_up gauge
+test_port_up{name="usw-1",port="1"} 3
+test_port_up{name="usw-1",port="2"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(dropLabels(reg, []string{"mac"}), strings.NewReader(expected)))
+}
+
+func TestLandingHandler(t *testing.T) {
+	h := landingHandler("/exporter/metrics")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `<a href="/exporter/metrics">`)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestProbeHandlerParams(t *testing.T) {
+	for _, url := range []string{"/probe", "/probe?target=bmc&module=ipmi"} {
+		rec := httptest.NewRecorder()
+		probeHandler((&Config{}).redfishLogin).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, url)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(file, []byte("s3cret\n"), 0o600))
+
+	cfg := &Config{RedfishPass: "inline", RedfishPassFile: file, UniFiPass: "inline"}
+	assert.NoError(t, cfg.loadSecrets())
+	assert.Equal(t, "s3cret", cfg.RedfishPass)
+	assert.Equal(t, "inline", cfg.UniFiPass)
+
+	cfg.UniFiPassFile = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, cfg.loadSecrets())
+}
+
+func TestBuildInfo(t *testing.T) {
+	expected := fmt.Sprintf(`
+# HELP home_lab_exporter_build_info Version, commit and Go version the exporter was built with
+# TYPE home_lab_exporter_build_info gauge
+home_lab_exporter_build_info{commit="unknown",go_version="%s",version="dev"} 1
+`, runtime.Version())
+	assert.NoError(t, testutil.CollectAndCompare(newBuildInfo(), strings.NewReader(expected)))
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{"both", Config{RedfishEnabled: true, RedfishTarget: "bmc", RedfishTimeout: time.Second, RedfishMaxRequests: 3, UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics"}, true},
+		{"redfish only", Config{RedfishEnabled: true, RedfishTarget: "bmc", RedfishTimeout: time.Second, RedfishMaxRequests: 3, WebTelemetryPath: "/metrics"}, true},
+		{"unifi only", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics"}, true},
+		{"none enabled", Config{RedfishTarget: "bmc", UniFiURL: "https://unifi"}, false},
+		{"ipmi only", Config{IPMITarget: "10.0.0.5", WebTelemetryPath: "/metrics"}, true},
+		{"missing redfish target", Config{RedfishEnabled: true, UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second}, false},
+		{"missing unifi url", Config{UniFiEnabled: true}, false},
+		{"zero unifi timeout", Config{UniFiEnabled: true, UniFiURL: "https://unifi"}, false},
+		{"zero redfish timeout", Config{RedfishEnabled: true, RedfishTarget: "bmc"}, false},
+		{"no concurrent redfish requests", Config{RedfishEnabled: true, RedfishTarget: "bmc", RedfishTimeout: time.Second, WebTelemetryPath: "/metrics"}, false},
+		{"tls cert without key", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTLSCert: "cert.pem"}, false},
+		{"auth user without password", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebAuthUser: "prometheus"}, false},
+		{"tls client ca without cert", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTLSClientCA: "ca.pem"}, false},
+		{"telemetry path behind proxy", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/exporter/metrics"}, true},
+		{"relative telemetry path", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "metrics"}, false},
+		{"telemetry path at root", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/"}, false},
+		{"fetch jitter", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", FetchJitter: 0.1}, true},
+		{"metric namespace", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", MetricNamespace: "homelab_"}, true},
+		{"invalid metric namespace", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", MetricNamespace: "home-lab_"}, false},
+		{"redfish smoothing", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", RedfishSmoothing: 0.3}, true},
+		{"redfish smoothing above 1", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", RedfishSmoothing: 1.5}, false},
+		{"negative fetch jitter", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", FetchJitter: -0.1}, false},
+		{"fetch jitter of a whole interval", Config{UniFiEnabled: true, UniFiURL: "https://unifi", UniFiTimeout: time.Second, WebTelemetryPath: "/metrics", FetchJitter: 1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate()
+		assert.Equal(t, tt.valid, err == nil, tt.name)
+	}
+}
+
+func TestRedfishCredentials(t *testing.T) {
+	cfg := &Config{
+		RedfishUser: "admin",
+		RedfishPass: "shared",
+		RedfishCredentials: []string{
+			"bmc1.example.com=root:calvin",
+			"[fd00::1]:443=ADMIN:pa:ss",
+		},
+	}
+	assert.NoError(t, cfg.parseRedfishCredentials())
+
+	user, password := cfg.redfishLogin("bmc1.example.com")
+	assert.Equal(t, "root", user)
+	assert.Equal(t, "calvin", password)
+	user, password = cfg.redfishLogin("[fd00::1]:443")
+	assert.Equal(t, "ADMIN", user)
+	assert.Equal(t, "pa:ss", password)
+	user, password = cfg.redfishLogin("bmc2.example.com")
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "shared", password)
+
+	for _, entry := range []string{"bmc1.example.com", "=root:calvin", "bmc1.example.com=root"} {
+		cfg.RedfishCredentials = []string{entry}
+		assert.Error(t, cfg.parseRedfishCredentials(), entry)
+	}
+}
+
+func TestUniFiHeaders(t *testing.T) {
+	cfg := &Config{UniFiHeaders: []string{"Authorization: Bearer proxy-token", "X-Forwarded-User:exporter"}}
+	assert.NoError(t, cfg.parseUniFiHeaders())
+
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &headerTransport{base: http.DefaultTransport, header: cfg.unifiHeader}}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer proxy-token", got.Get("Authorization"))
+	assert.Equal(t, "exporter", got.Get("X-Forwarded-User"))
+	// The caller's request is left alone
+	assert.Equal(t, "Basic dXNlcjpwYXNz", req.Header.Get("Authorization"))
+
+	for _, entry := range []string{"Authorization", ": Bearer proxy-token"} {
+		cfg.UniFiHeaders = []string{entry}
+		assert.Error(t, cfg.parseUniFiHeaders(), entry)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+listen: ":9200"
+redfish:
+  target: bmc1.example.com
+  user: file-user
+  stale-after: 1m
+  credentials:
+    - bmc2.example.com=root:calvin
+unifi:
+  url: https://unifi.example.com
+collector:
+  host:
+    enabled: true
+`), 0o600))
+	t.Setenv("REDFISH_USER", "env-user")
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	defineFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"--config", file, "--listen", ":9300"}))
+
+	cfg, err := loadConfig(fs)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9300", cfg.ListenAddr)
+	assert.Equal(t, "bmc1.example.com", cfg.RedfishTarget)
+	assert.Equal(t, "env-user", cfg.RedfishUser)
+	assert.Equal(t, time.Minute, cfg.RedfishStaleAfter)
+	assert.Equal(t, []string{"bmc2.example.com=root:calvin"}, cfg.RedfishCredentials)
+	assert.Equal(t, "https://unifi.example.com", cfg.UniFiURL)
+	assert.Equal(t, 10*time.Second, cfg.UniFiTimeout)
+	assert.Equal(t, "/metrics", cfg.WebTelemetryPath)
+	assert.True(t, cfg.HostEnabled)
+	assert.True(t, cfg.RedfishEnabled)
+
+	fs = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	defineFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}))
+	_, err = loadConfig(fs)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigUniFiPass(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	defineFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"--unifi.pass", "flag-secret", "--unifi.pass-file", "/run/secrets/unifi"}))
+	cfg, err := loadConfig(fs)
+	assert.NoError(t, err)
+	assert.Equal(t, "flag-secret", cfg.UniFiPass)
+	assert.Equal(t, "/run/secrets/unifi", cfg.UniFiPassFile)
+
+	t.Setenv("UNIFI_PASSWORD", "env-secret")
+	fs = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	defineFlags(fs)
+	assert.NoError(t, fs.Parse(nil))
+	cfg, err = loadConfig(fs)
+	assert.NoError(t, err)
+	assert.Equal(t, "env-secret", cfg.UniFiPass)
+}
+
+func TestTLSConfig(t *testing.T) {
+	tlsCfg, err := (&Config{}).tlsConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsCfg)
+
+	cfg := &Config{WebTLSCert: "cert.pem", WebTLSKey: "key.pem"}
+	tlsCfg, err = cfg.tlsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, tlsCfg.ClientAuth)
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	cfg.WebTLSClientCA = ca
+	tlsCfg, err = cfg.tlsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsCfg.ClientAuth)
+
+	assert.NoError(t, os.WriteFile(ca, []byte("not a certificate"), 0o600))
+	_, err = cfg.tlsConfig()
+	assert.Error(t, err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}), "prometheus", "s3cret")
+
+	for _, tt := range []struct {
+		user, password string
+		set            bool
+		code           int
+	}{
+		{"prometheus", "s3cret", true, http.StatusOK},
+		{"prometheus", "wrong", true, http.StatusUnauthorized},
+		{"other", "s3cret", true, http.StatusUnauthorized},
+		{"", "", false, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.set {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, tt.code, rec.Code, tt.user+":"+tt.password)
+	}
+
+	// Without a user the handler is served as is
+	rec := httptest.NewRecorder()
+	basicAuth(http.NotFoundHandler(), "", "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+type readyFunc func() bool
+
+func (f readyFunc) Ready() bool { return f() }
+
+func TestReadyHandler(t *testing.T) {
+	ready := false
+	h := readyHandler([]readyChecker{
+		readyFunc(func() bool { return true }),
+		readyFunc(func() bool { return ready }),
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	ready = true
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+type healthFunc func() collector.Health
+
+func (f healthFunc) Health() collector.Health { return f() }
+
+func TestHealthHandler(t *testing.T) {
+	last := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := healthHandler([]healthReporter{
+		healthFunc(func() collector.Health {
+			return collector.Health{Collector: "thermal", Up: true, LastSuccess: &last}
+		}),
+		healthFunc(func() collector.Health {
+			return collector.Health{Collector: "unifi", LastError: "connection refused"}
+		}),
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"collectors": [
+		{"collector": "thermal", "up": true, "last_success": "2025-01-01T00:00:00Z"},
+		{"collector": "unifi", "up": false, "last_error": "connection refused"}
+	]}`, rec.Body.String())
+}
+
+func TestPprofHandler(t *testing.T) {
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/goroutine"} {
+		rec := httptest.NewRecorder()
+		pprofHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	cfg := &Config{
+		ListenAddr:         ":9100",
+		RedfishPass:        "calvin",
+		UniFiPass:          "ubnt",
+		UniFiAPIToken:      "api-key",
+		UniFiHeaders:       []string{"Authorization: Bearer proxy-token"},
+		RedfishCredentials: []string{"[fd00::1]:443=ADMIN:pa:ss", "bmc1.example.com"},
+	}
+	rec := httptest.NewRecorder()
+	configHandler(cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, secret := range []string{"calvin", "ubnt", "api-key", "proxy-token", "pa:ss", "bmc1.example.com"} {
+		assert.NotContains(t, body, secret)
+	}
+	var got Config
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, ":9100", got.ListenAddr)
+	assert.Equal(t, "***", got.RedfishPass)
+	assert.Equal(t, "***", got.UniFiPass)
+	assert.Empty(t, got.IPMIPass)
+	assert.Equal(t, "***", got.UniFiAPIToken)
+	assert.Equal(t, []string{"Authorization: ***"}, got.UniFiHeaders)
+	assert.Equal(t, []string{"[fd00::1]:443=ADMIN:***", "***"}, got.RedfishCredentials)
+	// The handler must not redact the live config
+	assert.Equal(t, "calvin", cfg.RedfishPass)
+}
@@ -0,0 +1,37 @@
+package collector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CollectorGoroutines tracks the fetch goroutines alive per collector. A
+// value that keeps growing points at collectors being started without being
+// stopped.
+var CollectorGoroutines = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "home_lab_exporter_collector_goroutines",
+		Help: "Fetch goroutines currently running per collector",
+	},
+	[]string{"collector"},
+)
+
+// ActiveCollectors counts the collectors whose fetch loop is running. It
+// drops back to zero once every collector has been stopped on shutdown.
+var ActiveCollectors = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "home_lab_exporter_active_collectors",
+		Help: "Collectors with a running fetch loop",
+	},
+)
+
+// trackGoroutine marks a fetch goroutine of the named collector as running
+// and returns a func the goroutine calls when it exits. It is called before
+// the goroutine starts so the count is accurate as soon as the constructor
+// returns.
+func trackGoroutine(name string) func() {
+	g := CollectorGoroutines.WithLabelValues(name)
+	g.Inc()
+	ActiveCollectors.Inc()
+	return func() {
+		g.Dec()
+		ActiveCollectors.Dec()
+	}
+}
@@ -0,0 +1,34 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	unifi "github.com/unpoller/unifi/v5"
+)
+
+func TestCollectorGoroutines(t *testing.T) {
+	unifiBaseline := testutil.ToFloat64(CollectorGoroutines.WithLabelValues("unifi"))
+	thermalBaseline := testutil.ToFloat64(CollectorGoroutines.WithLabelValues("thermal"))
+	activeBaseline := testutil.ToFloat64(ActiveCollectors)
+
+	uc := NewUniFiCollectorWithClient(&mockClient{Devices: &unifi.Devices{}})
+	tc := NewThermalCollector(NewRedfishSession("", "", ""))
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(CollectorGoroutines.WithLabelValues("unifi")) == unifiBaseline+1 &&
+			testutil.ToFloat64(CollectorGoroutines.WithLabelValues("thermal")) == thermalBaseline+1 &&
+			testutil.ToFloat64(ActiveCollectors) == activeBaseline+2
+	}, time.Second, 10*time.Millisecond)
+
+	uc.Stop()
+	tc.Stop()
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(CollectorGoroutines.WithLabelValues("unifi")) == unifiBaseline &&
+			testutil.ToFloat64(CollectorGoroutines.WithLabelValues("thermal")) == thermalBaseline &&
+			testutil.ToFloat64(ActiveCollectors) == activeBaseline
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hostStats holds the host-level readings exposed by HostCollector.
+type hostStats struct {
+	Load1         float64
+	Load5         float64
+	Load15        float64
+	MemUsedBytes  float64
+	UptimeSeconds float64
+}
+
+// HostCollector exposes a few stats of the machine running the exporter.
+// Unlike the device collectors it reads procfs on every scrape, since the
+// reads are local and cheap. It emits nothing on non-Linux platforms.
+type HostCollector struct {
+	procPath    string
+	loadAverage *prometheus.GaugeVec
+	memoryUsed  prometheus.Gauge
+	uptime      prometheus.Gauge
+}
+
+func NewHostCollector(procPath string) *HostCollector {
+	return &HostCollector{
+		procPath: procPath,
+		loadAverage: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "home_lab_host_load_average",
+				Help: "Load average of the exporter host",
+			},
+			[]string{"period"},
+		),
+		memoryUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "home_lab_host_memory_used_bytes",
+			Help: "Memory in use on the exporter host (total minus available)",
+		}),
+		uptime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "home_lab_host_uptime_seconds",
+			Help: "Uptime of the exporter host",
+		}),
+	}
+}
+
+func (c *HostCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.loadAverage.Describe(ch)
+	c.memoryUsed.Describe(ch)
+	c.uptime.Describe(ch)
+}
+
+func (c *HostCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := readHostStats(c.procPath)
+	if err != nil {
+		log.Printf("Error reading host stats: %v", err)
+		return
+	}
+	if stats == nil {
+		return
+	}
+
+	c.loadAverage.WithLabelValues("1m").Set(stats.Load1)
+	c.loadAverage.WithLabelValues("5m").Set(stats.Load5)
+	c.loadAverage.WithLabelValues("15m").Set(stats.Load15)
+	c.memoryUsed.Set(stats.MemUsedBytes)
+	c.uptime.Set(stats.UptimeSeconds)
+
+	c.loadAverage.Collect(ch)
+	c.memoryUsed.Collect(ch)
+	c.uptime.Collect(ch)
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readHostStats reads load, memory and uptime from the procfs mounted at
+// procPath.
+func readHostStats(procPath string) (*hostStats, error) {
+	var stats hostStats
+
+	load, err := readFields(filepath.Join(procPath, "loadavg"), 3)
+	if err != nil {
+		return nil, err
+	}
+	stats.Load1, stats.Load5, stats.Load15 = load[0], load[1], load[2]
+
+	uptime, err := readFields(filepath.Join(procPath, "uptime"), 1)
+	if err != nil {
+		return nil, err
+	}
+	stats.UptimeSeconds = uptime[0]
+
+	mem, err := readMeminfo(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	stats.MemUsedBytes = (mem["MemTotal"] - mem["MemAvailable"]) * 1024
+
+	return &stats, nil
+}
+
+// readFields parses the first n whitespace-separated numbers of a file.
+func readFields(path string, n int) ([]float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < n {
+		return nil, fmt.Errorf("%s: expected %d fields, got %d", path, n, len(fields))
+	}
+	vals := make([]float64, n)
+	for i := range vals {
+		if vals[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return vals, nil
+}
+
+// readMeminfo parses /proc/meminfo into kB values keyed by field name.
+func readMeminfo(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mem := map[string]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			mem[key] = v
+		}
+	}
+	return mem, scanner.Err()
+}
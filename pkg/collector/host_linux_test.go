@@ -0,0 +1,34 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostCollector(t *testing.T) {
+	proc := t.TempDir()
+	files := map[string]string{
+		"loadavg": "0.52 0.58 0.59 1/467 12345\n",
+		"uptime":  "3600.25 7000.00\n",
+		"meminfo": "MemTotal:       16000000 kB\nMemFree:         2000000 kB\nMemAvailable:    6000000 kB\n",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(proc, name), []byte(content), 0o644))
+	}
+
+	col := NewHostCollector(proc)
+	assert.Equal(t, 5, testutil.CollectAndCount(col))
+	assert.Equal(t, 0.52, testutil.ToFloat64(col.loadAverage.WithLabelValues("1m")))
+	assert.Equal(t, 0.59, testutil.ToFloat64(col.loadAverage.WithLabelValues("15m")))
+	assert.Equal(t, 3600.25, testutil.ToFloat64(col.uptime))
+	assert.Equal(t, 10000000.0*1024, testutil.ToFloat64(col.memoryUsed))
+}
+
+func TestHostCollectorMissingProcfs(t *testing.T) {
+	col := NewHostCollector(filepath.Join(t.TempDir(), "missing"))
+	assert.Equal(t, 0, testutil.CollectAndCount(col))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collector
+
+// readHostStats is a no-op outside Linux; the host collector emits nothing.
+func readHostStats(string) (*hostStats, error) {
+	return nil, nil
+}
@@ -0,0 +1,229 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ipmiTimeout bounds a single ipmitool run. Older BMCs answer the full SDR
+// listing slowly, so it is longer than it would need to be for Redfish.
+const ipmiTimeout = 30 * time.Second
+
+// IPMISensor is a temperature or fan reading from the sensor data
+// repository of a BMC. Health uses the Redfish values, so that dashboards
+// built for Redfish sensors work for both.
+type IPMISensor struct {
+	Name    string
+	Reading float64
+	Health  string
+}
+
+type IPMIData struct {
+	Temperatures []IPMISensor
+	Fans         []IPMISensor
+}
+
+// IPMICollector reads temperatures and fan speeds from BMCs that do not
+// speak Redfish, using ipmitool over IPMI-over-LAN.
+type IPMICollector struct {
+	mutex    sync.Mutex
+	cache    IPMIData
+	runner   *runner
+	target   string
+	username string
+	password string
+	// sdr returns the output of "ipmitool sdr elist full"
+	sdr         func(ctx context.Context) ([]byte, error)
+	temperature *prometheus.GaugeVec
+	fanSpeed    *prometheus.GaugeVec
+	up          prometheus.Gauge
+	duration    prometheus.Gauge
+	lastScrape  prometheus.Gauge
+}
+
+func NewIPMICollector(target, username, password string) *IPMICollector {
+	collector := newIPMICollector(target, username, password)
+	collector.runner.start()
+	return collector
+}
+
+func newIPMICollector(target, username, password string) *IPMICollector {
+	c := &IPMICollector{
+		target:   target,
+		username: username,
+		password: password,
+		temperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("ipmi_temperature_celsius"),
+				Help: "Temperature readings from IPMI",
+			},
+			[]string{"sensor", "name", "target", "health"},
+		),
+		fanSpeed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("ipmi_fan_speed_rpm"),
+				Help: "Fan speeds from IPMI",
+			},
+			[]string{"fan", "name", "target", "health"},
+		),
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        metricName("ipmi_up"),
+			Help:        "Whether the last IPMI scrape succeeded (1) or not (0)",
+			ConstLabels: prometheus.Labels{"target": target},
+		}),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: metricName("ipmi_scrape_duration_seconds"),
+			Help: "Duration of the last IPMI fetch",
+		}),
+		lastScrape: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: metricName("ipmi_last_scrape_timestamp_seconds"),
+			Help: "Unix time of the last successful IPMI fetch",
+		}),
+	}
+	c.sdr = c.ipmitoolSDR
+	c.runner = newRunner("ipmi", c, scrapeMetrics{
+		up:         c.up,
+		duration:   prometheus.ObserverFunc(c.duration.Set),
+		lastScrape: c.lastScrape,
+	})
+	return c
+}
+
+func (c *IPMICollector) Describe(ch chan<- *prometheus.Desc) {
+	c.temperature.Describe(ch)
+	c.fanSpeed.Describe(ch)
+	c.up.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *IPMICollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = IPMIData{}
+	}
+
+	c.temperature.Reset()
+	for _, s := range c.cache.Temperatures {
+		c.temperature.WithLabelValues(s.Name, "temperature", c.target, s.Health).Set(s.Reading)
+	}
+	c.fanSpeed.Reset()
+	for _, s := range c.cache.Fans {
+		c.fanSpeed.WithLabelValues(s.Name, "fan", c.target, s.Health).Set(s.Reading)
+	}
+
+	c.temperature.Collect(ch)
+	c.fanSpeed.Collect(ch)
+	c.up.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *IPMICollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *IPMICollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *IPMICollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *IPMICollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ipmiTimeout)
+	defer cancel()
+	out, err := c.sdr(ctx)
+	if err != nil {
+		return fmt.Errorf("reading sensors: %w", err)
+	}
+	data := parseSDR(out)
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
+
+// ipmitoolSDR lists the sensors of the target with ipmitool. The password is
+// passed in the environment so that it does not show up in the process list.
+func (c *IPMICollector) ipmitoolSDR(ctx context.Context) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ipmitool",
+		"-I", "lanplus", "-H", c.target, "-U", c.username, "-E",
+		"sdr", "elist", "full")
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+c.password)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseSDR extracts the temperature and fan readings from the output of
+// "ipmitool sdr elist full", whose lines look like
+//
+//	CPU1 Temp        | 01h | ok  |  3.1 | 45 degrees C
+//
+// Sensors that are not present or have no reading are skipped.
+func parseSDR(out []byte) IPMIData {
+	var data IPMIData
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 5 {
+			continue
+		}
+		health, ok := sdrHealth(strings.TrimSpace(fields[2]))
+		if !ok {
+			continue
+		}
+		value, unit, ok := strings.Cut(strings.TrimSpace(fields[4]), " ")
+		if !ok {
+			continue
+		}
+		reading, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		sensor := IPMISensor{Name: strings.TrimSpace(fields[0]), Reading: reading, Health: health}
+		switch unit {
+		case "degrees C":
+			data.Temperatures = append(data.Temperatures, sensor)
+		case "RPM":
+			data.Fans = append(data.Fans, sensor)
+		}
+	}
+	return data
+}
+
+// sdrHealth maps an ipmitool sensor status to the matching Redfish health.
+// ok is false for sensors that are not present or not readable.
+func sdrHealth(status string) (health string, ok bool) {
+	switch status {
+	case "ok":
+		return "OK", true
+	case "nc", "lnc", "unc":
+		return "Warning", true
+	case "cr", "lcr", "ucr", "nr", "lnr", "unr":
+		return "Critical", true
+	}
+	return "", false
+}
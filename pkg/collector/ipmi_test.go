@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const sdrOutput = `CPU1 Temp        | 01h | ok  |  3.1 | 45 degrees C
+CPU2 Temp        | 02h | ns  |  3.2 | No Reading
+System Temp      | 0Bh | nc  |  7.1 | 71 degrees C
+FAN1             | 41h | ok  | 29.1 | 4200 RPM
+FAN2             | 42h | cr  | 29.2 | 300 RPM
+PS1 Status       | C8h | ok  | 10.1 | Presence detected
+12V              | 30h | ok  |  7.17 | 12.19 Volts
+`
+
+func TestParseSDR(t *testing.T) {
+	data := parseSDR([]byte(sdrOutput))
+
+	assert.Equal(t, []IPMISensor{
+		{Name: "CPU1 Temp", Reading: 45, Health: "OK"},
+		{Name: "System Temp", Reading: 71, Health: "Warning"},
+	}, data.Temperatures)
+	assert.Equal(t, []IPMISensor{
+		{Name: "FAN1", Reading: 4200, Health: "OK"},
+		{Name: "FAN2", Reading: 300, Health: "Critical"},
+	}, data.Fans)
+}
+
+func TestIPMICollector(t *testing.T) {
+	col := newIPMICollector("10.0.0.5", "admin", "secret")
+	col.sdr = func(context.Context) ([]byte, error) { return []byte(sdrOutput), nil }
+	assert.NoError(t, col.runner.scrape())
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "ipmi_temperature_celsius"))
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "ipmi_fan_speed_rpm"))
+	assert.Equal(t, 45.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "10.0.0.5", "OK")))
+	assert.Equal(t, 4200.0, testutil.ToFloat64(col.fanSpeed.WithLabelValues("FAN1", "fan", "10.0.0.5", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.up))
+	assert.True(t, col.Ready())
+
+	// A failed run keeps the previous readings
+	col.sdr = func(context.Context) ([]byte, error) {
+		return nil, errors.New("Unable to establish IPMI v2 / RMCP+ session")
+	}
+	assert.Error(t, col.runner.scrape())
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.up))
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "ipmi_temperature_celsius"))
+}
+
+func TestIPMICollectorCacheTTL(t *testing.T) {
+	col := newIPMICollector("10.0.0.5", "admin", "secret")
+	col.sdr = func(context.Context) ([]byte, error) { return []byte(sdrOutput), nil }
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	col.runner.now = func() time.Time { return now }
+	col.runner.ttl = time.Minute
+	assert.NoError(t, col.runner.scrape())
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "ipmi_temperature_celsius", "ipmi_fan_speed_rpm"))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "ipmi_up"))
+}
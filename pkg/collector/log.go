@@ -0,0 +1,168 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LogData summarizes the log entries of the BMC's log services, e.g. the
+// SEL.
+type LogData struct {
+	// Entries counts the entries by severity
+	Entries map[string]float64
+	// LastEntry is the creation time of the newest entry, zero if no entry
+	// has a valid timestamp
+	LastEntry time.Time
+}
+
+type LogCollector struct {
+	mutex      sync.Mutex
+	cache      LogData
+	runner     *runner
+	session    *RedfishSession
+	target     string
+	timeout    time.Duration
+	entries    *prometheus.CounterVec
+	lastEntry  *prometheus.GaugeVec
+	duration   prometheus.Histogram
+	lastScrape prometheus.Gauge
+}
+
+func NewLogCollector(session *RedfishSession) *LogCollector {
+	collector := newLogCollector(session)
+	collector.runner.start()
+	return collector
+}
+
+func newLogCollector(session *RedfishSession) *LogCollector {
+	collector := &LogCollector{
+		session: session,
+		target:  session.Target(),
+		timeout: RedfishTimeout,
+		entries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: metricName("redfish_log_entries_total"),
+				Help: "Entries in the BMC log services by severity",
+			},
+			[]string{"target", "severity"},
+		),
+		lastEntry: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_log_last_entry_timestamp_seconds"),
+				Help: "Creation time of the newest BMC log entry",
+			},
+			[]string{"target"},
+		),
+		duration:   newScrapeDuration("log"),
+		lastScrape: newLastScrape("log"),
+	}
+
+	collector.runner = newRunner("log", collector, scrapeMetrics{
+		duration:   collector.duration,
+		lastScrape: collector.lastScrape,
+	})
+	return collector
+}
+
+func (c *LogCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.entries.Describe(ch)
+	c.lastEntry.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *LogCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = LogData{}
+	}
+
+	c.entries.Reset()
+	c.lastEntry.Reset()
+	for severity, n := range c.cache.Entries {
+		c.entries.WithLabelValues(c.target, severity).Add(n)
+	}
+	if !c.cache.LastEntry.IsZero() {
+		c.lastEntry.WithLabelValues(c.target).Set(float64(c.cache.LastEntry.Unix()))
+	}
+
+	c.entries.Collect(ch)
+	c.lastEntry.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *LogCollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *LogCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *LogCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *LogCollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	client, err := c.session.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to Redfish target: %w", err)
+	}
+
+	managers, err := client.Service.Managers()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching managers: %w", err)
+	}
+	defer c.session.release(client)
+
+	data := LogData{Entries: map[string]float64{}}
+	for _, manager := range managers {
+		services, err := manager.LogServices()
+		if err != nil {
+			log.Printf("Error fetching log services for manager %s: %v", manager.Name, err)
+			continue
+		}
+		for _, service := range services {
+			entries, err := service.Entries()
+			if err != nil {
+				log.Printf("Error fetching entries for log service %s: %v", service.Name, err)
+				continue
+			}
+			for _, entry := range entries {
+				data.Entries[string(entry.Severity)]++
+				// Some BMCs leave Created empty
+				if t, err := time.Parse(time.RFC3339, entry.Created); err == nil && t.After(data.LastEntry) {
+					data.LastEntry = t
+				}
+			}
+		}
+	}
+
+	// Requests failed part way, so the counts are incomplete
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogCollector(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Managers": `{"Members": [{"@odata.id": "/redfish/v1/Managers/1"}]}`,
+		"/redfish/v1/Managers/1": `{
+			"@odata.id": "/redfish/v1/Managers/1", "Id": "1", "Name": "Manager",
+			"LogServices": {"@odata.id": "/redfish/v1/Managers/1/LogServices"}
+		}`,
+		"/redfish/v1/Managers/1/LogServices": `{"Members": [{"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel"}]}`,
+		"/redfish/v1/Managers/1/LogServices/Sel": `{
+			"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel", "Id": "Sel", "Name": "SEL Log",
+			"Entries": {"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel/Entries"}
+		}`,
+		"/redfish/v1/Managers/1/LogServices/Sel/Entries": `{"Members": [
+			{"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel/Entries/1"},
+			{"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel/Entries/2"},
+			{"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel/Entries/3"}
+		]}`,
+		"/redfish/v1/Managers/1/LogServices/Sel/Entries/1": `{"Id": "1", "Severity": "OK", "Created": "2025-01-01T10:00:00Z"}`,
+		"/redfish/v1/Managers/1/LogServices/Sel/Entries/2": `{"Id": "2", "Severity": "Critical", "Created": "2025-01-02T10:00:00+01:00"}`,
+		// Entries without a timestamp still count
+		"/redfish/v1/Managers/1/LogServices/Sel/Entries/3": `{"Id": "3", "Severity": "Critical"}`,
+	})
+
+	col := newLogCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "redfish_log_entries_total"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.entries.WithLabelValues(target, "OK")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.entries.WithLabelValues(target, "Critical")))
+	assert.Equal(t, float64(time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC).Unix()), testutil.ToFloat64(col.lastEntry.WithLabelValues(target)))
+}
+
+func TestLogCollectorTimeout(t *testing.T) {
+	resources := map[string]string{
+		"/redfish/v1":          redfishServiceRoot,
+		"/redfish/v1/Managers": `{"Members": [{"@odata.id": "/redfish/v1/Managers/1"}]}`,
+		"/redfish/v1/Managers/1": `{
+			"@odata.id": "/redfish/v1/Managers/1", "Id": "1", "Name": "Manager",
+			"LogServices": {"@odata.id": "/redfish/v1/Managers/1/LogServices"}
+		}`,
+		"/redfish/v1/Managers/1/LogServices": `{"Members": [{"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel"}]}`,
+		"/redfish/v1/Managers/1/LogServices/Sel": `{
+			"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel", "Id": "Sel", "Name": "SEL Log",
+			"Entries": {"@odata.id": "/redfish/v1/Managers/1/LogServices/Sel/Entries"}
+		}`,
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1/Managers/1/LogServices/Sel/Entries" {
+			// A half-dead BMC never answers
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(resources[path]))
+	}))
+	t.Cleanup(srv.Close)
+	target := strings.TrimPrefix(srv.URL, "https://")
+
+	col := newLogCollector(NewRedfishSession(target, "", ""))
+	col.timeout = 100 * time.Millisecond
+	assert.ErrorContains(t, col.fetch(context.Background()), "timed out")
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "redfish_log_entries_total"))
+}
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stmcginnis/gofish/common"
+)
+
+// MemoryModule holds the readings of a single DIMM.
+type MemoryModule struct {
+	Name                string
+	HasTemperature      bool
+	TemperatureCelsius  float64
+	HasErrors           bool
+	CorrectableErrors   float64
+	UncorrectableErrors float64
+}
+
+type MemoryData struct {
+	Modules []MemoryModule
+}
+
+type MemoryCollector struct {
+	mutex               sync.Mutex
+	cache               MemoryData
+	runner              *runner
+	session             *RedfishSession
+	target              string
+	timeout             time.Duration
+	temperature         *prometheus.GaugeVec
+	correctableErrors   *prometheus.CounterVec
+	uncorrectableErrors *prometheus.CounterVec
+	duration            prometheus.Histogram
+	lastScrape          prometheus.Gauge
+}
+
+func NewMemoryCollector(session *RedfishSession) *MemoryCollector {
+	labels := []string{"name", "target"}
+	collector := &MemoryCollector{
+		session: session,
+		target:  session.Target(),
+		timeout: RedfishTimeout,
+		temperature: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_memory_temperature_celsius"),
+				Help: "Memory module temperature from Redfish",
+			},
+			labels,
+		),
+		correctableErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: metricName("redfish_memory_correctable_errors_total"),
+				Help: "Correctable ECC errors over the lifetime of the memory module",
+			},
+			labels,
+		),
+		uncorrectableErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: metricName("redfish_memory_uncorrectable_errors_total"),
+				Help: "Uncorrectable ECC errors over the lifetime of the memory module",
+			},
+			labels,
+		),
+		duration:   newScrapeDuration("memory"),
+		lastScrape: newLastScrape("memory"),
+	}
+
+	collector.runner = newRunner("memory", collector, scrapeMetrics{
+		duration:   collector.duration,
+		lastScrape: collector.lastScrape,
+	})
+	collector.runner.start()
+	return collector
+}
+
+func (c *MemoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.temperature.Describe(ch)
+	c.correctableErrors.Describe(ch)
+	c.uncorrectableErrors.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *MemoryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = MemoryData{}
+	}
+
+	c.temperature.Reset()
+	c.correctableErrors.Reset()
+	c.uncorrectableErrors.Reset()
+	for _, m := range c.cache.Modules {
+		if m.HasTemperature {
+			c.temperature.WithLabelValues(m.Name, c.target).Set(m.TemperatureCelsius)
+		}
+		if m.HasErrors {
+			c.correctableErrors.WithLabelValues(m.Name, c.target).Add(m.CorrectableErrors)
+			c.uncorrectableErrors.WithLabelValues(m.Name, c.target).Add(m.UncorrectableErrors)
+		}
+	}
+
+	c.temperature.Collect(ch)
+	c.correctableErrors.Collect(ch)
+	c.uncorrectableErrors.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *MemoryCollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *MemoryCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *MemoryCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *MemoryCollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	client, err := c.session.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to Redfish target: %w", err)
+	}
+
+	systems, err := client.Service.Systems()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching systems: %w", err)
+	}
+	defer c.session.release(client)
+
+	var data MemoryData
+	for _, sys := range systems {
+		dimms, err := sys.Memory()
+		if err != nil {
+			log.Printf("Error fetching memory for system %s: %v", sys.Name, err)
+			continue
+		}
+		for _, dimm := range dimms {
+			if dimm.Status.State == common.AbsentState {
+				continue
+			}
+			m := MemoryModule{Name: dimm.Name}
+			if env, err := dimm.EnvironmentMetrics(); err == nil && env != nil && env.TemperatureCelsius.Reading != 0 {
+				m.HasTemperature = true
+				m.TemperatureCelsius = float64(env.TemperatureCelsius.Reading)
+			}
+			if metrics, err := dimm.Metrics(); err == nil && metrics != nil {
+				m.HasErrors = true
+				m.CorrectableErrors = float64(metrics.LifeTime.CorrectableECCErrorCount)
+				m.UncorrectableErrors = float64(metrics.LifeTime.UncorrectableECCErrorCount)
+			}
+			// Skip DIMMs that report neither temperature nor error counts
+			if m.HasTemperature || m.HasErrors {
+				data.Modules = append(data.Modules, m)
+			}
+		}
+	}
+
+	// Requests failed part way, so the readings are incomplete
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
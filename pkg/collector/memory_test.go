@@ -0,0 +1,36 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCollector(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Systems":          `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`,
+		"/redfish/v1/Systems/1":        `{"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System", "Memory": {"@odata.id": "/redfish/v1/Systems/1/Memory"}}`,
+		"/redfish/v1/Systems/1/Memory": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1/Memory/A1"}, {"@odata.id": "/redfish/v1/Systems/1/Memory/A2"}, {"@odata.id": "/redfish/v1/Systems/1/Memory/A3"}]}`,
+		"/redfish/v1/Systems/1/Memory/A1": `{
+			"@odata.id": "/redfish/v1/Systems/1/Memory/A1", "Id": "A1", "Name": "DIMM A1",
+			"Status": {"State": "Enabled", "Health": "OK"},
+			"Metrics": {"@odata.id": "/redfish/v1/Systems/1/Memory/A1/MemoryMetrics"},
+			"EnvironmentMetrics": {"@odata.id": "/redfish/v1/Systems/1/Memory/A1/EnvironmentMetrics"}
+		}`,
+		"/redfish/v1/Systems/1/Memory/A1/MemoryMetrics":      `{"Id": "Metrics", "LifeTime": {"CorrectableECCErrorCount": 12, "UncorrectableECCErrorCount": 1}}`,
+		"/redfish/v1/Systems/1/Memory/A1/EnvironmentMetrics": `{"Id": "EnvironmentMetrics", "TemperatureCelsius": {"Reading": 41.5}}`,
+		// A2 reports nothing and is skipped, A3 is an empty slot.
+		"/redfish/v1/Systems/1/Memory/A2": `{"@odata.id": "/redfish/v1/Systems/1/Memory/A2", "Id": "A2", "Name": "DIMM A2", "Status": {"State": "Enabled"}}`,
+		"/redfish/v1/Systems/1/Memory/A3": `{"@odata.id": "/redfish/v1/Systems/1/Memory/A3", "Id": "A3", "Name": "DIMM A3", "Status": {"State": "Absent"}}`,
+	})
+
+	col := NewMemoryCollector(NewRedfishSession(target, "", ""))
+	col.fetch(context.Background())
+
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "redfish_memory_temperature_celsius", "redfish_memory_correctable_errors_total", "redfish_memory_uncorrectable_errors_total"))
+	assert.Equal(t, 41.5, testutil.ToFloat64(col.temperature.WithLabelValues("DIMM A1", target)))
+	assert.Equal(t, 12.0, testutil.ToFloat64(col.correctableErrors.WithLabelValues("DIMM A1", target)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.uncorrectableErrors.WithLabelValues("DIMM A1", target)))
+}
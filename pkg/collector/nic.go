@@ -0,0 +1,221 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stmcginnis/gofish/common"
+	"github.com/stmcginnis/gofish/redfish"
+)
+
+// NIC holds the link of a single Ethernet interface of a system.
+type NIC struct {
+	Name string
+	MAC  string
+	// LinkStatus is LinkUp, LinkDown or NoLink, empty if not reported
+	LinkStatus string
+	// SpeedMbps is zero if not reported
+	SpeedMbps float64
+}
+
+type NICData struct {
+	NICs []NIC
+}
+
+type NICCollector struct {
+	mutex      sync.Mutex
+	cache      NICData
+	runner     *runner
+	session    *RedfishSession
+	target     string
+	timeout    time.Duration
+	linkStatus *prometheus.GaugeVec
+	speed      *prometheus.GaugeVec
+	duration   prometheus.Histogram
+	lastScrape prometheus.Gauge
+}
+
+func NewNICCollector(session *RedfishSession) *NICCollector {
+	collector := newNICCollector(session)
+	collector.runner.start()
+	return collector
+}
+
+func newNICCollector(session *RedfishSession) *NICCollector {
+	labels := []string{"target", "nic", "mac"}
+	collector := &NICCollector{
+		session: session,
+		target:  session.Target(),
+		timeout: RedfishTimeout,
+		linkStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_nic_link_status"),
+				Help: "Whether the link of the Ethernet interface is up (1) or not (0)",
+			},
+			labels,
+		),
+		speed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_nic_speed_mbps"),
+				Help: "Negotiated speed of the Ethernet interface (Mbps)",
+			},
+			labels,
+		),
+		duration:   newScrapeDuration("nic"),
+		lastScrape: newLastScrape("nic"),
+	}
+
+	collector.runner = newRunner("nic", collector, scrapeMetrics{
+		duration:   collector.duration,
+		lastScrape: collector.lastScrape,
+	})
+	return collector
+}
+
+func (c *NICCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.linkStatus.Describe(ch)
+	c.speed.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *NICCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = NICData{}
+	}
+
+	c.linkStatus.Reset()
+	c.speed.Reset()
+	for _, nic := range c.cache.NICs {
+		if nic.LinkStatus != "" {
+			up := 0.0
+			if nic.LinkStatus == string(redfish.LinkUpLinkStatus) {
+				up = 1
+			}
+			c.linkStatus.WithLabelValues(c.target, nic.Name, nic.MAC).Set(up)
+		}
+		if nic.SpeedMbps > 0 {
+			c.speed.WithLabelValues(c.target, nic.Name, nic.MAC).Set(nic.SpeedMbps)
+		}
+	}
+
+	c.linkStatus.Collect(ch)
+	c.speed.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *NICCollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *NICCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *NICCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *NICCollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	client, err := c.session.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to Redfish target: %w", err)
+	}
+
+	systems, err := client.Service.Systems()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching systems: %w", err)
+	}
+	defer c.session.release(client)
+
+	var data NICData
+	for _, sys := range systems {
+		for _, iface := range ethernetInterfaces(sys) {
+			if iface.Status.State == common.AbsentState {
+				continue
+			}
+			name := iface.Name
+			if name == "" {
+				name = iface.ID
+			}
+			mac := iface.MACAddress
+			if mac == "" {
+				mac = iface.PermanentMACAddress
+			}
+			data.NICs = append(data.NICs, NIC{
+				Name:       name,
+				MAC:        mac,
+				LinkStatus: string(iface.LinkStatus),
+				SpeedMbps:  float64(iface.SpeedMbps),
+			})
+		}
+	}
+
+	// Requests failed part way, so the NICs are incomplete
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
+
+// ethernetInterfaces returns the Ethernet interfaces of sys, both those the
+// system lists and those linked from the functions of its PCIe devices, where
+// some BMCs put add-in cards. Interfaces listed twice are returned once.
+func ethernetInterfaces(sys *redfish.ComputerSystem) []*redfish.EthernetInterface {
+	interfaces, err := sys.EthernetInterfaces()
+	if err != nil {
+		log.Printf("Error fetching Ethernet interfaces for system %s: %v", sys.Name, err)
+	}
+	devices, err := sys.PCIeDevices()
+	if err != nil {
+		log.Printf("Error fetching PCIe devices for system %s: %v", sys.Name, err)
+	}
+	for _, device := range devices {
+		functions, err := device.PCIeFunctions()
+		if err != nil {
+			log.Printf("Error fetching PCIe functions of device %s: %v", device.Name, err)
+			continue
+		}
+		for _, function := range functions {
+			linked, err := function.EthernetInterfaces()
+			if err != nil {
+				log.Printf("Error fetching Ethernet interfaces of PCIe function %s: %v", function.Name, err)
+				continue
+			}
+			interfaces = append(interfaces, linked...)
+		}
+	}
+
+	seen := map[string]bool{}
+	var unique []*redfish.EthernetInterface
+	for _, iface := range interfaces {
+		if seen[iface.ODataID] {
+			continue
+		}
+		seen[iface.ODataID] = true
+		unique = append(unique, iface)
+	}
+	return unique
+}
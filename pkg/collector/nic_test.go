@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNICCollector(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Systems": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`,
+		"/redfish/v1/Systems/1": `{
+			"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System",
+			"EthernetInterfaces": {"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces"},
+			"PCIeDevices": [{"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3"}]
+		}`,
+		"/redfish/v1/Systems/1/EthernetInterfaces": `{"Members": [
+			{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/1"},
+			{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/2"},
+			{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/3"}
+		]}`,
+		// Negotiated down to 100 Mbps
+		"/redfish/v1/Systems/1/EthernetInterfaces/1": `{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/1", "Id": "1", "Name": "NIC.Integrated.1-1", "MACAddress": "aa:bb:cc:00:00:01", "LinkStatus": "LinkUp", "SpeedMbps": 100}`,
+		"/redfish/v1/Systems/1/EthernetInterfaces/2": `{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/2", "Id": "2", "Name": "NIC.Integrated.1-2", "MACAddress": "aa:bb:cc:00:00:02", "LinkStatus": "LinkDown"}`,
+		"/redfish/v1/Systems/1/EthernetInterfaces/3": `{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/3", "Id": "3", "Name": "NIC.Slot.2-1", "Status": {"State": "Absent"}}`,
+		// The add-in card is only linked from its PCIe function, next to an
+		// interface the system lists already
+		"/redfish/v1/Chassis/1/PCIeDevices/3":               `{"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3", "Id": "3", "Name": "Slot 3", "PCIeFunctions": {"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3/PCIeFunctions"}}`,
+		"/redfish/v1/Chassis/1/PCIeDevices/3/PCIeFunctions": `{"Members": [{"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3/PCIeFunctions/0"}]}`,
+		"/redfish/v1/Chassis/1/PCIeDevices/3/PCIeFunctions/0": `{
+			"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3/PCIeFunctions/0", "Id": "0", "Name": "Function 0",
+			"Links": {"EthernetInterfaces": [
+				{"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/1"},
+				{"@odata.id": "/redfish/v1/Chassis/1/NetworkAdapters/3/EthernetInterfaces/1"}
+			]}
+		}`,
+		"/redfish/v1/Chassis/1/NetworkAdapters/3/EthernetInterfaces/1": `{"@odata.id": "/redfish/v1/Chassis/1/NetworkAdapters/3/EthernetInterfaces/1", "Id": "1", "Name": "NIC.Slot.3-1", "MACAddress": "aa:bb:cc:00:00:31", "LinkStatus": "LinkUp", "SpeedMbps": 25000}`,
+	})
+
+	col := newNICCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := fmt.Sprintf(`
+# HELP redfish_nic_link_status Whether the link of the Ethernet interface is up (1) or not (0)
+# TYPE redfish_nic_link_status gauge
+redfish_nic_link_status{mac="aa:bb:cc:00:00:01",nic="NIC.Integrated.1-1",target=%[1]q} 1
+redfish_nic_link_status{mac="aa:bb:cc:00:00:02",nic="NIC.Integrated.1-2",target=%[1]q} 0
+redfish_nic_link_status{mac="aa:bb:cc:00:00:31",nic="NIC.Slot.3-1",target=%[1]q} 1
+# HELP redfish_nic_speed_mbps Negotiated speed of the Ethernet interface (Mbps)
+# TYPE redfish_nic_speed_mbps gauge
+redfish_nic_speed_mbps{mac="aa:bb:cc:00:00:01",nic="NIC.Integrated.1-1",target=%[1]q} 100
+redfish_nic_speed_mbps{mac="aa:bb:cc:00:00:31",nic="NIC.Slot.3-1",target=%[1]q} 25000
+`, target)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "redfish_nic_link_status", "redfish_nic_speed_mbps"))
+}
+
+func TestNICCollectorCancelled(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Systems": `{"Members": []}`,
+	})
+	col := newNICCollector(NewRedfishSession(target, "", ""))
+	col.cache = NICData{NICs: []NIC{{Name: "NIC.Integrated.1-1", MAC: "aa:bb:cc:00:00:01", LinkStatus: "LinkUp"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, col.fetch(ctx), context.Canceled)
+	// The NICs of the last complete fetch are kept
+	assert.Len(t, col.cache.NICs, 1)
+}
+
+func TestNICCollectorTimeout(t *testing.T) {
+	resources := map[string]string{
+		"/redfish/v1":         redfishServiceRoot,
+		"/redfish/v1/Systems": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`,
+		"/redfish/v1/Systems/1": `{
+			"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System",
+			"PCIeDevices": [{"@odata.id": "/redfish/v1/Chassis/1/PCIeDevices/3"}]
+		}`,
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1/Chassis/1/PCIeDevices/3" {
+			// A half-dead BMC never answers
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(resources[path]))
+	}))
+	t.Cleanup(srv.Close)
+
+	col := newNICCollector(NewRedfishSession(strings.TrimPrefix(srv.URL, "https://"), "", ""))
+	col.cache = NICData{NICs: []NIC{{Name: "NIC.Integrated.1-1", MAC: "aa:bb:cc:00:00:01", LinkStatus: "LinkUp"}}}
+	col.timeout = 100 * time.Millisecond
+	assert.ErrorContains(t, col.fetch(context.Background()), "timed out")
+	assert.Len(t, col.cache.NICs, 1)
+}
@@ -0,0 +1,40 @@
+package collector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// PowerSource is a collector that knows the power drawn by what it monitors.
+// ThermalCollector and UniFiCollector implement it.
+type PowerSource interface {
+	PowerWatts() float64
+}
+
+// TotalPowerCollector sums the power of several collectors into a coarse
+// estimate of the power drawn by the whole home lab. It reads their caches
+// and runs no fetch of its own.
+type TotalPowerCollector struct {
+	sources []PowerSource
+	total   *prometheus.Desc
+}
+
+func NewTotalPowerCollector(sources ...PowerSource) *TotalPowerCollector {
+	return &TotalPowerCollector{
+		sources: sources,
+		total: prometheus.NewDesc(
+			"home_lab_total_power_watts",
+			"Power consumed by the Redfish targets plus the PoE power drawn from UniFi switches (W)",
+			nil, nil,
+		),
+	}
+}
+
+func (c *TotalPowerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+}
+
+func (c *TotalPowerCollector) Collect(ch chan<- prometheus.Metric) {
+	total := 0.0
+	for _, s := range c.sources {
+		total += s.PowerWatts()
+	}
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, total)
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	unifi "github.com/unpoller/unifi/v5"
+)
+
+func TestTotalPowerCollector(t *testing.T) {
+	thermal := newThermalCollector(NewRedfishSession(newRedfishMock(t, chassisResources()), "", ""))
+	assert.NoError(t, thermal.fetch(context.Background()))
+
+	usw := &unifi.USW{Name: "usw-poe", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{
+		{Name: "Port 1", PortIdx: *unifi.NewFlexInt(1), PoeEnable: unifi.FlexBool{Val: true, Txt: "true"}, PoePower: *unifi.NewFlexInt(12.5)},
+		// Disabled ports may still report a stale reading
+		{Name: "Port 2", PortIdx: *unifi.NewFlexInt(2), PoePower: *unifi.NewFlexInt(6)},
+	}
+	uc := newUniFiCollectorWithClient(&mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw}},
+	})
+	assert.NoError(t, uc.fetch(context.Background()))
+
+	expected := `
+# HELP home_lab_total_power_watts Power consumed by the Redfish targets plus the PoE power drawn from UniFi switches (W)
+# TYPE home_lab_total_power_watts gauge
+home_lab_total_power_watts 194.5
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewTotalPowerCollector(thermal, uc), strings.NewReader(expected)))
+}
+
+func TestMetricNamespace(t *testing.T) {
+	MetricNamespace = "homelab_"
+	defer func() { MetricNamespace = "" }()
+	assert.Equal(t, "homelab_unifi_up", metricName("unifi_up"))
+
+	// The exporter's own home_lab_* metrics keep their names
+	expected := `
+# HELP home_lab_total_power_watts Power consumed by the Redfish targets plus the PoE power drawn from UniFi switches (W)
+# TYPE home_lab_total_power_watts gauge
+home_lab_total_power_watts 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewTotalPowerCollector(), strings.NewReader(expected)))
+}
@@ -0,0 +1,196 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/common"
+)
+
+// RedfishTimeout bounds a single fetch from a Redfish target, including
+// login. Collectors read it when they are created.
+var RedfishTimeout = 10 * time.Second
+
+// RedfishMaxConcurrentRequests limits the requests a collector has in flight
+// to a Redfish target at once. Sessions read it when they are created.
+var RedfishMaxConcurrentRequests = 3
+
+// RedfishReuseConnections keeps the connections to a Redfish target open
+// between the requests of a fetch. Some BMCs misbehave with keep-alive.
+// Sessions read it when they are created.
+var RedfishReuseConnections = true
+
+// redfishEndpoint returns the URL of a Redfish target, which is either a URL
+// or a host with an optional port. HTTPS is assumed unless the target names
+// a scheme, and IPv6 addresses are bracketed.
+func redfishEndpoint(target string) string {
+	if strings.Contains(target, "://") {
+		return strings.TrimSuffix(target, "/")
+	}
+	// A bare IPv6 address; with brackets it may carry a port
+	if ip := net.ParseIP(target); ip != nil && strings.Contains(target, ":") {
+		target = "[" + target + "]"
+	}
+	return "https://" + target
+}
+
+// RedfishSession is a login to a Redfish target that the collectors of the
+// target share, so that they hold a single session on the BMC rather than
+// one each. It logs in on first use and again after a failed fetch.
+type RedfishSession struct {
+	target        string
+	username      string
+	password      string
+	maxConcurrent int
+	reuseConns    bool
+	mutex         sync.Mutex
+	session       *gofish.Session // nil until logged in
+}
+
+func NewRedfishSession(target, username, password string) *RedfishSession {
+	return &RedfishSession{
+		target:        target,
+		username:      username,
+		password:      password,
+		maxConcurrent: RedfishMaxConcurrentRequests,
+		reuseConns:    RedfishReuseConnections,
+	}
+}
+
+// config returns the gofish settings for the target, logging in with the
+// given credentials unless a session is set on them.
+func (s *RedfishSession) config(username, password string) gofish.ClientConfig {
+	return gofish.ClientConfig{
+		Endpoint:              redfishEndpoint(s.target),
+		Username:              username,
+		Password:              password,
+		Insecure:              true, // Set to false if you want to verify SSL certificates
+		MaxConcurrentRequests: int64(s.maxConcurrent),
+		ReuseConnections:      s.reuseConns,
+	}
+}
+
+// Target returns the address of the Redfish target.
+func (s *RedfishSession) Target() string {
+	return s.target
+}
+
+// connect returns a client on the shared session, logging in if there is
+// none yet. All requests of the client are aborted once ctx is done. The
+// caller must pass the client to release or reset when it is done with it.
+func (s *RedfishSession) connect(ctx context.Context) (*gofish.APIClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.session != nil {
+		cfg := s.config("", "")
+		cfg.Session = s.session
+		return gofish.ConnectContext(ctx, cfg)
+	}
+	client, err := gofish.ConnectContext(ctx, s.config(s.username, s.password))
+	if err != nil {
+		return nil, err
+	}
+	// Without credentials there is no session to share
+	if session, err := client.GetSession(); err == nil {
+		s.session = session
+	}
+	return client, nil
+}
+
+// release closes the connections of client and keeps the session logged in
+// for the next fetch.
+func (s *RedfishSession) release(client *gofish.APIClient) {
+	client.HTTPClient.CloseIdleConnections()
+}
+
+// reset closes the connections of client after the fetch failed with err.
+// Only an authentication failure means the BMC expired the shared session,
+// which is then logged out so that the next connect logs in again. Other
+// errors, e.g. a timeout, leave it to the collectors still using it.
+func (s *RedfishSession) reset(client *gofish.APIClient, err error) {
+	client.HTTPClient.CloseIdleConnections()
+	if !unauthorized(err) {
+		return
+	}
+	session, err := client.GetSession()
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Another collector may have logged in again already
+	if s.session != nil && s.session.ID == session.ID {
+		s.logout()
+	}
+}
+
+// unauthorized reports whether err is a 401 from the BMC. gofish reports
+// failed collection reads as a CollectionError, which does not unwrap.
+func unauthorized(err error) bool {
+	var redfishErr *common.Error
+	if errors.As(err, &redfishErr) {
+		return redfishErr.HTTPReturnedStatusCode == http.StatusUnauthorized
+	}
+	var collectionErr *common.CollectionError
+	if errors.As(err, &collectionErr) {
+		for _, failure := range collectionErr.Failures {
+			if unauthorized(failure) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Close logs out of the shared session. Call it once the collectors using
+// the session have stopped.
+func (s *RedfishSession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.session != nil {
+		s.logout()
+	}
+}
+
+// logout ends the shared session on the BMC and forgets it. It connects with
+// a timeout of its own, as the context of a failed fetch may be done already.
+// The caller must hold the mutex.
+func (s *RedfishSession) logout() {
+	ctx, cancel := context.WithTimeout(context.Background(), RedfishTimeout)
+	defer cancel()
+	cfg := s.config("", "")
+	cfg.Session = s.session
+	if client, err := gofish.ConnectContext(ctx, cfg); err == nil {
+		client.Logout()
+	}
+	s.session = nil
+}
+
+// newScrapeDuration returns the redfish_scrape_duration_seconds histogram of
+// the named Redfish collector. Its buckets reach far enough to catch a BMC
+// that occasionally takes tens of seconds to answer.
+func newScrapeDuration(collector string) prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        metricName("redfish_scrape_duration_seconds"),
+		Help:        "Duration of the Redfish fetches",
+		ConstLabels: prometheus.Labels{"collector": collector},
+		Buckets:     []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30},
+	})
+}
+
+// newLastScrape returns the redfish_last_scrape_timestamp_seconds gauge of
+// the named Redfish collector.
+func newLastScrape(collector string) prometheus.Gauge {
+	return prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricName("redfish_last_scrape_timestamp_seconds"),
+		Help:        "Unix time of the last successful Redfish fetch",
+		ConstLabels: prometheus.Labels{"collector": collector},
+	})
+}
@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// redfishServiceRoot is the minimal service root the mock Redfish server
+// serves at /redfish/v1/.
+const redfishServiceRoot = `{
+	"@odata.id": "/redfish/v1/",
+	"Id": "RootService",
+	"Chassis": {"@odata.id": "/redfish/v1/Chassis"},
+	"Managers": {"@odata.id": "/redfish/v1/Managers"},
+	"Systems": {"@odata.id": "/redfish/v1/Systems"},
+	"Links": {"Sessions": {"@odata.id": "/redfish/v1/SessionService/Sessions"}}
+}`
+
+// newRedfishMock starts a TLS server serving canned Redfish JSON keyed by
+// path and returns its address as a collector target.
+func newRedfishMock(t *testing.T, resources map[string]string) string {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1" {
+			w.Write([]byte(redfishServiceRoot))
+			return
+		}
+		body, ok := resources[path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "https://")
+}
+
+func TestRedfishEndpoint(t *testing.T) {
+	tests := []struct {
+		target, want string
+	}{
+		{"bmc.example.com", "https://bmc.example.com"},
+		{"host:8443", "https://host:8443"},
+		{"192.168.1.10", "https://192.168.1.10"},
+		{"fe80::1", "https://[fe80::1]"},
+		{"[fe80::1]", "https://[fe80::1]"},
+		{"[fe80::1]:8443", "https://[fe80::1]:8443"},
+		{"https://host", "https://host"},
+		{"http://host:8000/", "http://host:8000"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, redfishEndpoint(tt.target), tt.target)
+	}
+}
+
+func TestRedfishSessionConfig(t *testing.T) {
+	cfg := NewRedfishSession("bmc", "", "").config("root", "calvin")
+	assert.Equal(t, int64(3), cfg.MaxConcurrentRequests)
+	assert.True(t, cfg.ReuseConnections)
+
+	RedfishMaxConcurrentRequests, RedfishReuseConnections = 1, false
+	defer func() { RedfishMaxConcurrentRequests, RedfishReuseConnections = 3, true }()
+	cfg = NewRedfishSession("bmc", "", "").config("root", "calvin")
+	assert.Equal(t, int64(1), cfg.MaxConcurrentRequests)
+	assert.False(t, cfg.ReuseConnections)
+	assert.Equal(t, "https://bmc", cfg.Endpoint)
+}
+
+func TestRedfishSessionShared(t *testing.T) {
+	resources := chassisResources()
+	resources["/redfish/v1/Systems"] = `{"Members": []}`
+	resources["/redfish/v1/Managers"] = `{"Members": []}`
+	var logins, logouts atomic.Int32
+	var loggedIn atomic.Bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		switch {
+		case path == "/redfish/v1":
+			w.Write([]byte(redfishServiceRoot))
+		case r.Method == http.MethodPost && path == "/redfish/v1/SessionService/Sessions":
+			logins.Add(1)
+			loggedIn.Store(true)
+			w.Header().Set("Location", "/redfish/v1/SessionService/Sessions/1")
+			w.Header().Set("X-Auth-Token", "secret-token")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete:
+			logouts.Add(1)
+			loggedIn.Store(false)
+		case !loggedIn.Load() || r.Header.Get("X-Auth-Token") != "secret-token":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		default:
+			w.Write([]byte(resources[path]))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	session := NewRedfishSession(strings.TrimPrefix(srv.URL, "https://"), "root", "calvin")
+
+	thermal := newThermalCollector(session)
+	system := newSystemCollector(session)
+	assert.NoError(t, thermal.fetch(context.Background()))
+	assert.NoError(t, system.fetch(context.Background()))
+	assert.NoError(t, thermal.fetch(context.Background()))
+	assert.Equal(t, int32(1), logins.Load())
+
+	// The BMC expired the session, so the next fetch fails and the one after
+	// logs in again
+	loggedIn.Store(false)
+	assert.Error(t, system.fetch(context.Background()))
+	assert.NoError(t, thermal.fetch(context.Background()))
+	assert.Equal(t, int32(2), logins.Load())
+
+	session.Close()
+	assert.Equal(t, int32(2), logouts.Load())
+}
+
+func TestRedfishSessionKeptOnFailure(t *testing.T) {
+	resources := chassisResources()
+	resources["/redfish/v1/Managers"] = `{"Members": []}`
+	var logins, logouts atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		switch {
+		case path == "/redfish/v1":
+			w.Write([]byte(redfishServiceRoot))
+		case r.Method == http.MethodPost && path == "/redfish/v1/SessionService/Sessions":
+			logins.Add(1)
+			w.Header().Set("Location", "/redfish/v1/SessionService/Sessions/1")
+			w.Header().Set("X-Auth-Token", "secret-token")
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete:
+			logouts.Add(1)
+		case path == "/redfish/v1/Systems":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		default:
+			w.Write([]byte(resources[path]))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	session := NewRedfishSession(strings.TrimPrefix(srv.URL, "https://"), "root", "calvin")
+
+	// A failure other than a 401 leaves the session to the other collectors
+	system := newSystemCollector(session)
+	thermal := newThermalCollector(session)
+	assert.Error(t, system.fetch(context.Background()))
+	assert.NoError(t, thermal.fetch(context.Background()))
+	assert.Equal(t, int32(1), logins.Load())
+	assert.Equal(t, int32(0), logouts.Load())
+
+	session.Close()
+	assert.Equal(t, int32(1), logouts.Load())
+}
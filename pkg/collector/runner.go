@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fetchInterval is how often a runner refreshes the cache of its collector.
+const fetchInterval = 30 * time.Second
+
+// FetchJitter randomly stretches or shrinks every fetch interval by up to
+// this fraction, so that exporters started together do not hit a shared
+// controller or BMC in lockstep. Collectors read it when they are created.
+var FetchJitter float64
+
+// CacheTTL stops collectors from serving their cache once this long has
+// passed since their last successful fetch. Zero keeps serving it forever.
+// Collectors read it when they are created.
+var CacheTTL time.Duration
+
+// MetricNamespace is prepended to the name of every metric the collectors
+// export, so homelab_ turns unifi_up into homelab_unifi_up. The exporter's
+// own home_lab_* metrics, such as home_lab_total_power_watts, keep their
+// names. Collectors read it when they are created.
+var MetricNamespace string
+
+// metricName returns name prefixed with MetricNamespace.
+func metricName(name string) string {
+	return MetricNamespace + name
+}
+
+// CachingCollector is a collector that serves its metrics from a cache which
+// fetch refreshes in the background. fetch must give up once ctx is done.
+type CachingCollector interface {
+	prometheus.Collector
+	fetch(ctx context.Context) error
+}
+
+// scrapeMetrics are the metrics a runner keeps about the fetches of its
+// collector. The collector describes and collects them with its own metrics.
+// up may be nil for collectors that do not export it. duration observes the
+// duration of every fetch; wrap a gauge in prometheus.ObserverFunc to keep
+// only the last one.
+type scrapeMetrics struct {
+	up         prometheus.Gauge
+	duration   prometheus.Observer
+	lastScrape prometheus.Gauge
+}
+
+// runner drives the fetch loop of a CachingCollector and records the outcome
+// of every fetch in its scrapeMetrics.
+type runner struct {
+	name      string
+	collector CachingCollector
+	metrics   scrapeMetrics
+	now       func() time.Time
+	ttl       time.Duration
+	jitter    float64
+	ready     atomic.Bool  // set after the first successful fetch
+	up        atomic.Bool  // whether the last fetch succeeded
+	lastOK    atomic.Int64 // Unix nanoseconds of the last successful fetch
+	lastErr   atomic.Pointer[string]
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   chan struct{} // closed when the loop exits, nil if never started
+}
+
+// newRunner returns a runner for the named collector. It does not fetch
+// until start or scrape is called.
+func newRunner(name string, collector CachingCollector, metrics scrapeMetrics) *runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &runner{
+		name:      name,
+		collector: collector,
+		metrics:   metrics,
+		now:       time.Now,
+		ttl:       CacheTTL,
+		jitter:    FetchJitter,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// start runs the fetch loop in the background until Stop is called.
+func (r *runner) start() {
+	r.stopped = make(chan struct{})
+	go r.run(trackGoroutine(r.name))
+}
+
+func (r *runner) run(done func()) {
+	// Stop returns only after the goroutine is no longer counted
+	defer close(r.stopped)
+	defer done()
+	// Started before the first fetch so that the interval does not grow by
+	// the duration of each fetch
+	timer := time.NewTimer(r.interval())
+	defer timer.Stop()
+
+	for {
+		if err := r.scrape(); err != nil {
+			log.Printf("Error fetching %s data: %v", r.name, err)
+		}
+		select {
+		case <-timer.C:
+			timer.Reset(r.interval())
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// interval returns the time until the next fetch: fetchInterval with the
+// jitter applied.
+func (r *runner) interval() time.Duration {
+	if r.jitter <= 0 {
+		return fetchInterval
+	}
+	return time.Duration(float64(fetchInterval) * (1 + r.jitter*(2*rand.Float64()-1)))
+}
+
+// scrape runs a single fetch and records its outcome.
+func (r *runner) scrape() error {
+	start := time.Now()
+	err := r.collector.fetch(r.ctx)
+	r.metrics.duration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		msg := err.Error()
+		r.lastErr.Store(&msg)
+		r.up.Store(false)
+		r.setUp(0)
+		return err
+	}
+	r.lastErr.Store(nil)
+	r.up.Store(true)
+	r.setUp(1)
+	now := r.now()
+	r.metrics.lastScrape.Set(float64(now.Unix()))
+	r.lastOK.Store(now.UnixNano())
+	r.ready.Store(true)
+	return nil
+}
+
+func (r *runner) setUp(v float64) {
+	if r.metrics.up != nil {
+		r.metrics.up.Set(v)
+	}
+}
+
+// stale reports whether the cache has outlived the TTL since the last
+// successful fetch. Collectors drop a stale cache rather than serve frozen
+// values that hide an outage.
+func (r *runner) stale() bool {
+	last := r.lastOK.Load()
+	if r.ttl <= 0 || last == 0 {
+		return false
+	}
+	return r.now().Sub(time.Unix(0, last)) > r.ttl
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (r *runner) Ready() bool {
+	return r.ready.Load()
+}
+
+// Health summarizes the fetches of a collector for the /health endpoint.
+type Health struct {
+	Collector string `json:"collector"`
+	// Up reports whether the last fetch succeeded
+	Up bool `json:"up"`
+	// LastSuccess is the time of the last successful fetch, nil if there
+	// has been none
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// LastError is the error of the last fetch, if it failed
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Health returns the outcome of the fetches so far.
+func (r *runner) Health() Health {
+	h := Health{Collector: r.name, Up: r.up.Load()}
+	if last := r.lastOK.Load(); last != 0 {
+		t := time.Unix(0, last)
+		h.LastSuccess = &t
+	}
+	if msg := r.lastErr.Load(); msg != nil {
+		h.LastError = *msg
+	}
+	return h
+}
+
+// Stop cancels a fetch in progress and waits for the loop to exit. It must
+// be called at most once.
+func (r *runner) Stop() {
+	r.cancel()
+	if r.stopped != nil {
+		<-r.stopped
+	}
+}
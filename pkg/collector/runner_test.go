@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCollector blocks in fetch until ctx is done if block is set, and
+// otherwise returns err.
+type fakeCollector struct {
+	prometheus.Collector
+	err   error
+	block bool
+}
+
+func (f *fakeCollector) fetch(ctx context.Context) error {
+	if f.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return f.err
+}
+
+func newTestScrapeMetrics() scrapeMetrics {
+	return scrapeMetrics{
+		up:         prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_up"}),
+		duration:   prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration"}),
+		lastScrape: prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_last_scrape"}),
+	}
+}
+
+func TestRunnerScrape(t *testing.T) {
+	fc := &fakeCollector{}
+	metrics := newTestScrapeMetrics()
+	r := newRunner("test", fc, metrics)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	assert.False(t, r.Ready())
+	assert.NoError(t, r.scrape())
+	assert.True(t, r.Ready())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.up))
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(metrics.lastScrape))
+
+	// A failed fetch keeps the time of the last successful one
+	fc.err = errors.New("target unreachable")
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, r.scrape(), fc.err)
+	assert.True(t, r.Ready())
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.up))
+	assert.Equal(t, float64(now.Add(-time.Minute).Unix()), testutil.ToFloat64(metrics.lastScrape))
+}
+
+func TestRunnerStale(t *testing.T) {
+	fc := &fakeCollector{}
+	r := newRunner("test", fc, newTestScrapeMetrics())
+	r.ttl = 2 * time.Minute
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	// Nothing is cached before the first fetch
+	assert.False(t, r.stale())
+	assert.NoError(t, r.scrape())
+
+	fc.err = errors.New("target unreachable")
+	now = now.Add(time.Minute)
+	assert.Error(t, r.scrape())
+	assert.False(t, r.stale())
+	now = now.Add(2 * time.Minute)
+	assert.True(t, r.stale())
+
+	// Without a TTL the cache never goes stale
+	r.ttl = 0
+	assert.False(t, r.stale())
+}
+
+func TestRunnerStopCancelsFetch(t *testing.T) {
+	baseline := testutil.ToFloat64(CollectorGoroutines.WithLabelValues("test"))
+	r := newRunner("test", &fakeCollector{block: true}, newTestScrapeMetrics())
+	r.start()
+	assert.Equal(t, baseline+1, testutil.ToFloat64(CollectorGoroutines.WithLabelValues("test")))
+
+	// Stop returns only once the blocked fetch has given up
+	r.Stop()
+	assert.Equal(t, baseline, testutil.ToFloat64(CollectorGoroutines.WithLabelValues("test")))
+	assert.False(t, r.Ready())
+}
+
+func TestRunnerInterval(t *testing.T) {
+	r := newRunner("test", &fakeCollector{}, newTestScrapeMetrics())
+	assert.Equal(t, fetchInterval, r.interval())
+
+	r.jitter = 0.1
+	for i := 0; i < 100; i++ {
+		d := r.interval()
+		assert.GreaterOrEqual(t, d, 27*time.Second)
+		assert.LessOrEqual(t, d, 33*time.Second)
+	}
+}
+
+func TestRunnerHealth(t *testing.T) {
+	fc := &fakeCollector{err: errors.New("connection refused")}
+	r := newRunner("test", fc, newTestScrapeMetrics())
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	assert.Equal(t, Health{Collector: "test"}, r.Health())
+
+	assert.Error(t, r.scrape())
+	assert.Equal(t, Health{Collector: "test", LastError: "connection refused"}, r.Health())
+
+	fc.err = nil
+	assert.NoError(t, r.scrape())
+	h := r.Health()
+	assert.True(t, h.Up)
+	assert.Empty(t, h.LastError)
+	assert.True(t, now.Equal(*h.LastSuccess))
+}
@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stmcginnis/gofish/common"
+	"github.com/stmcginnis/gofish/redfish"
+)
+
+// StorageController holds the health of a RAID controller and of its cache
+// battery, if it has one.
+type StorageController struct {
+	Name          string
+	Health        common.Health
+	BatteryHealth common.Health
+}
+
+// Drive holds the health and SMART failure prediction of a physical disk.
+type Drive struct {
+	Name             string
+	Serial           string
+	Model            string
+	Health           common.Health
+	CapacityBytes    float64
+	FailurePredicted bool
+}
+
+type StorageData struct {
+	Controllers []StorageController
+	Drives      []Drive
+}
+
+type StorageCollector struct {
+	mutex            sync.Mutex
+	cache            StorageData
+	runner           *runner
+	session          *RedfishSession
+	target           string
+	timeout          time.Duration
+	controllerHealth *prometheus.GaugeVec
+	batteryHealth    *prometheus.GaugeVec
+	driveHealth      *prometheus.GaugeVec
+	driveCapacity    *prometheus.GaugeVec
+	drivePredicted   *prometheus.GaugeVec
+	duration         prometheus.Histogram
+	lastScrape       prometheus.Gauge
+}
+
+func NewStorageCollector(session *RedfishSession) *StorageCollector {
+	labels := []string{"name", "target"}
+	driveLabels := []string{"target", "drive", "serial", "model"}
+	collector := &StorageCollector{
+		session: session,
+		target:  session.Target(),
+		timeout: RedfishTimeout,
+		controllerHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_storage_controller_health"),
+				Help: "Storage controller health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			labels,
+		),
+		batteryHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_raid_battery_health"),
+				Help: "RAID cache battery health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			labels,
+		),
+		driveHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_drive_health"),
+				Help: "Drive health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			driveLabels,
+		),
+		driveCapacity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_drive_capacity_bytes"),
+				Help: "Drive capacity in bytes",
+			},
+			driveLabels,
+		),
+		drivePredicted: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_drive_predicted_failure"),
+				Help: "1 if the drive predicts its own failure (SMART), 0 otherwise",
+			},
+			driveLabels,
+		),
+		duration:   newScrapeDuration("storage"),
+		lastScrape: newLastScrape("storage"),
+	}
+
+	collector.runner = newRunner("storage", collector, scrapeMetrics{
+		duration:   collector.duration,
+		lastScrape: collector.lastScrape,
+	})
+	collector.runner.start()
+	return collector
+}
+
+func (c *StorageCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.controllerHealth.Describe(ch)
+	c.batteryHealth.Describe(ch)
+	c.driveHealth.Describe(ch)
+	c.driveCapacity.Describe(ch)
+	c.drivePredicted.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *StorageCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = StorageData{}
+	}
+
+	c.controllerHealth.Reset()
+	c.batteryHealth.Reset()
+	c.driveHealth.Reset()
+	c.driveCapacity.Reset()
+	c.drivePredicted.Reset()
+	for _, ctrl := range c.cache.Controllers {
+		if v, ok := psuHealthValue(string(ctrl.Health)); ok {
+			c.controllerHealth.WithLabelValues(ctrl.Name, c.target).Set(v)
+		}
+		if v, ok := psuHealthValue(string(ctrl.BatteryHealth)); ok {
+			c.batteryHealth.WithLabelValues(ctrl.Name, c.target).Set(v)
+		}
+	}
+	for _, d := range c.cache.Drives {
+		if v, ok := psuHealthValue(string(d.Health)); ok {
+			c.driveHealth.WithLabelValues(c.target, d.Name, d.Serial, d.Model).Set(v)
+		}
+		if d.CapacityBytes > 0 {
+			c.driveCapacity.WithLabelValues(c.target, d.Name, d.Serial, d.Model).Set(d.CapacityBytes)
+		}
+		predicted := 0.0
+		if d.FailurePredicted {
+			predicted = 1
+		}
+		c.drivePredicted.WithLabelValues(c.target, d.Name, d.Serial, d.Model).Set(predicted)
+	}
+
+	c.controllerHealth.Collect(ch)
+	c.batteryHealth.Collect(ch)
+	c.driveHealth.Collect(ch)
+	c.driveCapacity.Collect(ch)
+	c.drivePredicted.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *StorageCollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *StorageCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *StorageCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *StorageCollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	client, err := c.session.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to Redfish target: %w", err)
+	}
+
+	systems, err := client.Service.Systems()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching systems: %w", err)
+	}
+	defer c.session.release(client)
+
+	var data StorageData
+	for _, sys := range systems {
+		storages, err := sys.Storage()
+		if err != nil {
+			log.Printf("Error fetching storage for system %s: %v", sys.Name, err)
+			continue
+		}
+		// Systems without a RAID controller have no controllers here
+		for _, storage := range storages {
+			data.Controllers = append(data.Controllers, storageControllers(storage)...)
+			data.Drives = append(data.Drives, storageDrives(storage)...)
+		}
+	}
+
+	// Requests failed part way, so the readings are incomplete
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
+
+// storageControllers returns the controllers of a storage subsystem. Newer
+// BMCs link them as separate resources, older ones embed them in the
+// deprecated StorageControllers array, which carries no battery links.
+func storageControllers(storage *redfish.Storage) []StorageController {
+	controllers, err := storage.Controllers()
+	if err != nil {
+		log.Printf("Error fetching controllers for storage %s: %v", storage.Name, err)
+	}
+
+	var result []StorageController
+	if len(controllers) == 0 {
+		for _, ctrl := range storage.StorageControllers {
+			result = append(result, StorageController{Name: ctrl.Name, Health: ctrl.Status.Health})
+		}
+		return result
+	}
+
+	for _, ctrl := range controllers {
+		sc := StorageController{Name: ctrl.Name, Health: ctrl.Status.Health}
+		batteries, err := ctrl.Batteries()
+		if err != nil {
+			log.Printf("Error fetching batteries for controller %s: %v", ctrl.Name, err)
+		}
+		// A controller has at most one cache battery in practice
+		for _, battery := range batteries {
+			if battery.Status.State != common.AbsentState {
+				sc.BatteryHealth = battery.Status.Health
+				break
+			}
+		}
+		result = append(result, sc)
+	}
+	return result
+}
+
+// storageDrives returns the drives of a storage subsystem, skipping empty
+// bays.
+func storageDrives(storage *redfish.Storage) []Drive {
+	drives, err := storage.Drives()
+	if err != nil {
+		log.Printf("Error fetching drives for storage %s: %v", storage.Name, err)
+	}
+
+	var result []Drive
+	for _, d := range drives {
+		if d.Status.State == common.AbsentState {
+			continue
+		}
+		result = append(result, Drive{
+			Name:             d.Name,
+			Serial:           d.SerialNumber,
+			Model:            d.Model,
+			Health:           d.Status.Health,
+			CapacityBytes:    float64(d.CapacityBytes),
+			FailurePredicted: d.FailurePredicted,
+		})
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageCollector(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Systems":   `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}, {"@odata.id": "/redfish/v1/Systems/2"}]}`,
+		"/redfish/v1/Systems/1": `{"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System", "Storage": {"@odata.id": "/redfish/v1/Systems/1/Storage"}}`,
+		// System 2 has no RAID controller
+		"/redfish/v1/Systems/2":         `{"@odata.id": "/redfish/v1/Systems/2", "Id": "2", "Name": "System 2"}`,
+		"/redfish/v1/Systems/1/Storage": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID"}]}`,
+		"/redfish/v1/Systems/1/Storage/RAID": `{
+			"@odata.id": "/redfish/v1/Systems/1/Storage/RAID", "Id": "RAID", "Name": "RAID Storage",
+			"Controllers": {"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Controllers"},
+			"Drives": [
+				{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/0"},
+				{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/1"},
+				{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/2"}
+			]
+		}`,
+		"/redfish/v1/Systems/1/Storage/RAID/Controllers": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Controllers/0"}]}`,
+		"/redfish/v1/Systems/1/Storage/RAID/Controllers/0": `{
+			"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Controllers/0", "Id": "0", "Name": "PERC H730P",
+			"Status": {"State": "Enabled", "Health": "OK"},
+			"Links": {"Batteries": [{"@odata.id": "/redfish/v1/Chassis/1/PowerSubsystem/Batteries/1"}]}
+		}`,
+		"/redfish/v1/Systems/1/Storage/RAID/Drives/0": `{
+			"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/0", "Id": "0", "Name": "Disk 0",
+			"Model": "ST4000NM0035", "SerialNumber": "ZC10AAAA", "CapacityBytes": 4000787030016,
+			"FailurePredicted": false, "Status": {"State": "Enabled", "Health": "OK"}
+		}`,
+		"/redfish/v1/Systems/1/Storage/RAID/Drives/1": `{
+			"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/1", "Id": "1", "Name": "Disk 1",
+			"Model": "ST4000NM0035", "SerialNumber": "ZC10BBBB", "CapacityBytes": 4000787030016,
+			"FailurePredicted": true, "Status": {"State": "Enabled", "Health": "Warning"}
+		}`,
+		// Empty bay
+		"/redfish/v1/Systems/1/Storage/RAID/Drives/2":      `{"@odata.id": "/redfish/v1/Systems/1/Storage/RAID/Drives/2", "Id": "2", "Name": "Disk 2", "Status": {"State": "Absent"}}`,
+		"/redfish/v1/Chassis/1/PowerSubsystem/Batteries/1": `{"@odata.id": "/redfish/v1/Chassis/1/PowerSubsystem/Batteries/1", "Id": "1", "Name": "Cache Battery", "Status": {"State": "Enabled", "Health": "Warning"}}`,
+	})
+
+	col := NewStorageCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.runner.scrape())
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "redfish_storage_controller_health", "redfish_raid_battery_health"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.controllerHealth.WithLabelValues("PERC H730P", target)))
+	assert.Equal(t, 0.5, testutil.ToFloat64(col.batteryHealth.WithLabelValues("PERC H730P", target)))
+	assert.Equal(t, 6, testutil.CollectAndCount(col, "redfish_drive_health", "redfish_drive_capacity_bytes", "redfish_drive_predicted_failure"))
+	assert.Equal(t, 0.5, testutil.ToFloat64(col.driveHealth.WithLabelValues(target, "Disk 1", "ZC10BBBB", "ST4000NM0035")))
+	assert.Equal(t, 4000787030016.0, testutil.ToFloat64(col.driveCapacity.WithLabelValues(target, "Disk 0", "ZC10AAAA", "ST4000NM0035")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.drivePredicted.WithLabelValues(target, "Disk 0", "ZC10AAAA", "ST4000NM0035")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.drivePredicted.WithLabelValues(target, "Disk 1", "ZC10BBBB", "ST4000NM0035")))
+	var duration dto.Metric
+	assert.NoError(t, col.duration.Write(&duration))
+	assert.Equal(t, uint64(1), duration.GetHistogram().GetSampleCount())
+	assert.Greater(t, duration.GetHistogram().GetSampleSum(), 0.0)
+}
+
+func TestStorageCollectorTimeout(t *testing.T) {
+	resources := map[string]string{
+		"/redfish/v1":           redfishServiceRoot,
+		"/redfish/v1/Systems":   `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}, {"@odata.id": "/redfish/v1/Systems/2"}]}`,
+		"/redfish/v1/Systems/1": `{"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System"}`,
+		"/redfish/v1/Systems/2": `{"@odata.id": "/redfish/v1/Systems/2", "Id": "2", "Name": "System 2", "Storage": {"@odata.id": "/redfish/v1/Systems/2/Storage"}}`,
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1/Systems/2/Storage" {
+			// A half-dead BMC never answers
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(resources[path]))
+	}))
+	t.Cleanup(srv.Close)
+	target := strings.TrimPrefix(srv.URL, "https://")
+
+	// No runner, the fetch is driven by hand
+	col := &StorageCollector{session: NewRedfishSession(target, "", ""), timeout: 100 * time.Millisecond}
+	assert.ErrorContains(t, col.fetch(context.Background()), "timed out")
+	assert.Empty(t, col.cache.Controllers)
+	assert.Empty(t, col.cache.Drives)
+}
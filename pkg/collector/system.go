@@ -0,0 +1,227 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stmcginnis/gofish/common"
+)
+
+// System holds the summary of a Redfish computer system.
+type System struct {
+	Name           string
+	Health         common.Health
+	ProcessorCount int
+	MemoryBytes    float64
+}
+
+// Manager holds the identity and clock of a BMC.
+type Manager struct {
+	FirmwareVersion string
+	Model           string
+	// ClockOffset is how far the BMC clock is ahead of the exporter's, in
+	// seconds. It is only valid if HasClock is set.
+	ClockOffset float64
+	HasClock    bool
+}
+
+type SystemData struct {
+	Systems  []System
+	Managers []Manager
+}
+
+type SystemCollector struct {
+	mutex          sync.Mutex
+	cache          SystemData
+	runner         *runner
+	session        *RedfishSession
+	target         string
+	timeout        time.Duration
+	now            func() time.Time
+	health         *prometheus.GaugeVec
+	processorCount *prometheus.GaugeVec
+	memoryTotal    *prometheus.GaugeVec
+	managerInfo    *prometheus.GaugeVec
+	clockOffset    *prometheus.GaugeVec
+	duration       prometheus.Histogram
+	lastScrape     prometheus.Gauge
+}
+
+func NewSystemCollector(session *RedfishSession) *SystemCollector {
+	collector := newSystemCollector(session)
+	collector.runner.start()
+	return collector
+}
+
+func newSystemCollector(session *RedfishSession) *SystemCollector {
+	labels := []string{"name", "target"}
+	collector := &SystemCollector{
+		session: session,
+		target:  session.Target(),
+		timeout: RedfishTimeout,
+		now:     time.Now,
+		health: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_system_health"),
+				Help: "Rolled-up system health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			labels,
+		),
+		processorCount: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_processor_count"),
+				Help: "Number of physical processors in the system",
+			},
+			labels,
+		),
+		memoryTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_memory_total_bytes"),
+				Help: "Total system memory in bytes",
+			},
+			labels,
+		),
+		managerInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_manager_info"),
+				Help: "Firmware version and model of the BMC, always 1",
+			},
+			[]string{"target", "firmware_version", "model"},
+		),
+		clockOffset: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_manager_datetime_offset_seconds"),
+				Help: "Difference between the BMC clock and the exporter's clock, positive if the BMC is ahead",
+			},
+			[]string{"target"},
+		),
+		duration:   newScrapeDuration("system"),
+		lastScrape: newLastScrape("system"),
+	}
+
+	collector.runner = newRunner("system", collector, scrapeMetrics{
+		duration:   collector.duration,
+		lastScrape: collector.lastScrape,
+	})
+	return collector
+}
+
+func (c *SystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.health.Describe(ch)
+	c.processorCount.Describe(ch)
+	c.memoryTotal.Describe(ch)
+	c.managerInfo.Describe(ch)
+	c.clockOffset.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
+}
+
+func (c *SystemCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = SystemData{}
+	}
+
+	c.health.Reset()
+	c.processorCount.Reset()
+	c.memoryTotal.Reset()
+	c.managerInfo.Reset()
+	c.clockOffset.Reset()
+	for _, sys := range c.cache.Systems {
+		if v, ok := psuHealthValue(string(sys.Health)); ok {
+			c.health.WithLabelValues(sys.Name, c.target).Set(v)
+		}
+		c.processorCount.WithLabelValues(sys.Name, c.target).Set(float64(sys.ProcessorCount))
+		c.memoryTotal.WithLabelValues(sys.Name, c.target).Set(sys.MemoryBytes)
+	}
+	for _, m := range c.cache.Managers {
+		c.managerInfo.WithLabelValues(c.target, m.FirmwareVersion, m.Model).Set(1)
+		if m.HasClock {
+			c.clockOffset.WithLabelValues(c.target).Set(m.ClockOffset)
+		}
+	}
+
+	c.health.Collect(ch)
+	c.processorCount.Collect(ch)
+	c.memoryTotal.Collect(ch)
+	c.managerInfo.Collect(ch)
+	c.clockOffset.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *SystemCollector) Ready() bool {
+	return c.runner.Ready()
+}
+
+// Health summarizes the fetches so far.
+func (c *SystemCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *SystemCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *SystemCollector) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	client, err := c.session.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to Redfish target: %w", err)
+	}
+
+	systems, err := client.Service.Systems()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching systems: %w", err)
+	}
+	defer c.session.release(client)
+
+	var data SystemData
+	for _, sys := range systems {
+		data.Systems = append(data.Systems, System{
+			Name:           sys.Name,
+			Health:         sys.Status.Health,
+			ProcessorCount: sys.ProcessorSummary.Count,
+			MemoryBytes:    float64(sys.MemorySummary.TotalSystemMemoryGiB) * (1 << 30),
+		})
+	}
+
+	managers, err := client.Service.Managers()
+	if err != nil {
+		c.session.reset(client, err)
+		return fmt.Errorf("fetching managers: %w", err)
+	}
+	now := c.now()
+	for _, m := range managers {
+		manager := Manager{FirmwareVersion: m.FirmwareVersion, Model: m.Model}
+		// Some BMCs leave DateTime empty
+		if t, err := time.Parse(time.RFC3339, m.DateTime); err == nil {
+			manager.ClockOffset = t.Sub(now).Seconds()
+			manager.HasClock = true
+		}
+		data.Managers = append(data.Managers, manager)
+	}
+
+	// Requests failed part way, so the data is incomplete
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	c.cache = data
+	c.mutex.Unlock()
+	return nil
+}
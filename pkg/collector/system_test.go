@@ -0,0 +1,39 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemCollector(t *testing.T) {
+	target := newRedfishMock(t, map[string]string{
+		"/redfish/v1/Systems": `{"Members": [{"@odata.id": "/redfish/v1/Systems/1"}]}`,
+		"/redfish/v1/Systems/1": `{
+			"@odata.id": "/redfish/v1/Systems/1", "Id": "1", "Name": "System",
+			"Status": {"State": "Enabled", "Health": "Warning", "HealthRollup": "Warning"},
+			"ProcessorSummary": {"Count": 2, "LogicalProcessorCount": 48},
+			"MemorySummary": {"TotalSystemMemoryGiB": 128}
+		}`,
+		"/redfish/v1/Managers": `{"Members": [{"@odata.id": "/redfish/v1/Managers/iDRAC.Embedded.1"}]}`,
+		"/redfish/v1/Managers/iDRAC.Embedded.1": `{
+			"@odata.id": "/redfish/v1/Managers/iDRAC.Embedded.1", "Id": "iDRAC.Embedded.1",
+			"FirmwareVersion": "7.00.00.171", "Model": "14G Monolithic",
+			"DateTime": "2025-01-01T12:00:30+01:00"
+		}`,
+	})
+
+	col := newSystemCollector(NewRedfishSession(target, "", ""))
+	col.now = func() time.Time { return time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC) }
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "redfish_system_health", "redfish_processor_count", "redfish_memory_total_bytes"))
+	assert.Equal(t, 0.5, testutil.ToFloat64(col.health.WithLabelValues("System", target)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.processorCount.WithLabelValues("System", target)))
+	assert.Equal(t, 128.0*(1<<30), testutil.ToFloat64(col.memoryTotal.WithLabelValues("System", target)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.managerInfo.WithLabelValues(target, "7.00.00.171", "14G Monolithic")))
+	assert.Equal(t, 30.0, testutil.ToFloat64(col.clockOffset.WithLabelValues(target)))
+}
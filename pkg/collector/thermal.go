@@ -1,180 +1,771 @@
 package collector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/common"
+	"github.com/stmcginnis/gofish/redfish"
 )
 
 type ThermalData struct {
-	Temperatures []struct {
-		Name           string  `json:"Name"`
-		ReadingCelsius float64 `json:"ReadingCelsius"`
-		Status         struct {
-			Health string `json:"Health"`
-		} `json:"Status"`
-	} `json:"Temperatures"`
-	Fans []struct {
-		Name    string  `json:"Name"`
-		Reading float64 `json:"Reading"`
-		Status  struct {
-			Health string `json:"Health"`
-		} `json:"Status"`
-	} `json:"Fans"`
+	Temperatures  []TemperatureReading `json:"Temperatures"`
+	Fans          []FanReading         `json:"Fans"`
+	PowerControls []PowerControl       `json:"PowerControls"`
+	PowerSupplies []PowerSupply        `json:"PowerSupplies"`
+	Redundancies  []PowerRedundancy    `json:"Redundancies"`
+	Sensors       []SensorReading      `json:"Sensors"`
 }
 
+// SensorStatus is the Redfish status of a thermal sensor.
+type SensorStatus struct {
+	Health string `json:"Health"`
+}
+
+// TemperatureReading is a temperature sensor of the named chassis. Zero
+// thresholds were not reported by the BMC.
+type TemperatureReading struct {
+	Name                      string       `json:"Name"`
+	Chassis                   string       `json:"Chassis"`
+	ReadingCelsius            float64      `json:"ReadingCelsius"`
+	UpperThresholdCritical    float64      `json:"UpperThresholdCritical"`
+	UpperThresholdNonCritical float64      `json:"UpperThresholdNonCritical"`
+	LowerThresholdCritical    float64      `json:"LowerThresholdCritical"`
+	LowerThresholdNonCritical float64      `json:"LowerThresholdNonCritical"`
+	Status                    SensorStatus `json:"Status"`
+}
+
+// FanReading is a fan of the named chassis.
+type FanReading struct {
+	Name    string       `json:"Name"`
+	Chassis string       `json:"Chassis"`
+	Reading float64      `json:"Reading"`
+	Status  SensorStatus `json:"Status"`
+}
+
+// SensorReading is a sensor of the named chassis other than a temperature
+// or fan, e.g. humidity or airflow. Unit is as reported by the BMC, or the
+// reading type if it reports none.
+type SensorReading struct {
+	Name    string  `json:"Name"`
+	Chassis string  `json:"Chassis"`
+	Unit    string  `json:"Unit"`
+	Reading float64 `json:"Reading"`
+}
+
+// PowerControl holds the power drawn and budgeted for a chassis, as read from
+// the Power resource. Zero means the BMC did not report the value.
+type PowerControl struct {
+	Name          string  `json:"Name"`
+	ConsumedWatts float64 `json:"ConsumedWatts"`
+	CapacityWatts float64 `json:"CapacityWatts"`
+}
+
+// PowerSupply holds the readings of a single PSU from the Power resource.
+type PowerSupply struct {
+	ID          string  `json:"MemberId"`
+	Name        string  `json:"Name"`
+	Model       string  `json:"Model"`
+	InputWatts  float64 `json:"InputWatts"`
+	OutputWatts float64 `json:"OutputWatts"`
+	Health      string  `json:"Health"`
+}
+
+// PowerRedundancy is a redundancy set of PSUs from the Power resource. It
+// turns unhealthy when a PSU fails even though the others still carry the
+// load.
+type PowerRedundancy struct {
+	Name    string `json:"Name"`
+	Enabled bool   `json:"Enabled"`
+	Health  string `json:"Health"`
+}
+
+// redfishClient is the part of a Redfish service that the ThermalCollector
+// reads. *gofish.Service implements it.
+type redfishClient interface {
+	Chassis() ([]*redfish.Chassis, error)
+}
+
+// redfishConnector returns the client for a single fetch, whose requests are
+// aborted once ctx is done. The fetch calls done with its error, nil if it
+// succeeded.
+type redfishConnector func(ctx context.Context) (client redfishClient, done func(err error), err error)
+
 type ThermalCollector struct {
+	// SkipUnknownHealth omits sensors whose health is empty or "Unknown",
+	// which many BMCs report for absent or unpopulated sensors.
+	SkipUnknownHealth bool
+	// StaleAfter stops serving cached readings once the target has been
+	// unreachable for longer than this. Zero keeps serving them forever.
+	StaleAfter time.Duration
+	// Smoothing, if above zero, exports temperatures as an exponentially
+	// weighted moving average of the fetched readings, in which each new
+	// reading has this weight. Zero exports the raw readings.
+	Smoothing float64
+
 	mutex       sync.Mutex
 	cache       ThermalData
+	up          bool      // whether the last fetch succeeded
+	lastSuccess time.Time // when the cache was last refreshed
+	now         func() time.Time
+	timeout     time.Duration
+	runner      *runner
+	connect     redfishConnector
 	target      string
-	username    string
-	password    string
 	temperature *prometheus.GaugeVec
 	fanSpeed    *prometheus.GaugeVec
+	tempHealth  *prometheus.GaugeVec
+	upperCrit   *prometheus.GaugeVec
+	upperWarn   *prometheus.GaugeVec
+	lowerCrit   *prometheus.GaugeVec
+	lowerWarn   *prometheus.GaugeVec
+	fanHealth   *prometheus.GaugeVec
+	fanMin      *prometheus.GaugeVec
+	fanMax      *prometheus.GaugeVec
+	// fanRanges holds the extremes of every fan read since the last Collect,
+	// keyed by chassis and fan name
+	fanRanges  map[sensorKey]*fanRange
+	smoothed   map[sensorKey]float64 // averaged temperatures if Smoothing is on
+	powerUsed  *prometheus.GaugeVec
+	powerCap   *prometheus.GaugeVec
+	psuInput   *prometheus.GaugeVec
+	psuOutput  *prometheus.GaugeVec
+	psuHealth  *prometheus.GaugeVec
+	rsHealth   *prometheus.GaugeVec // PSU redundancy sets
+	rsEnabled  *prometheus.GaugeVec
+	sensor     *prometheus.GaugeVec
+	redfishUp  *prometheus.GaugeVec
+	duration   prometheus.Histogram
+	lastScrape prometheus.Gauge
+}
+
+func NewThermalCollector(session *RedfishSession) *ThermalCollector {
+	collector := newThermalCollector(session)
+	collector.runner.start()
+	return collector
+}
+
+// NewThermalCollectorWithClient returns a ThermalCollector that reads target
+// through client rather than connecting itself. The fetch timeout cannot
+// abort the requests of client, so it must bound them on its own.
+func NewThermalCollectorWithClient(target string, client redfishClient) *ThermalCollector {
+	collector := newThermalCollectorWithClient(target, client)
+	collector.runner.start()
+	return collector
+}
+
+// ProbeThermal scrapes target once and returns a collector serving the
+// result. It runs no background loop and logs out before returning, so it
+// suits per-request registries such as a /probe handler.
+func ProbeThermal(target, username, password string) *ThermalCollector {
+	session := NewRedfishSession(target, username, password)
+	defer session.Close()
+	collector := newThermalCollector(session)
+	if err := collector.runner.scrape(); err != nil {
+		log.Printf("Error probing Redfish target %s: %v", target, err)
+	}
+	return collector
+}
+
+func newThermalCollector(session *RedfishSession) *ThermalCollector {
+	return newThermalCollectorWith(session.Target(), func(ctx context.Context) (redfishClient, func(error), error) {
+		client, err := session.connect(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		done := func(err error) {
+			if err == nil {
+				session.release(client)
+			} else {
+				session.reset(client, err)
+			}
+		}
+		return client.Service, done, nil
+	})
 }
 
-func NewThermalCollector(target, username, password string) *ThermalCollector {
-	collector := &ThermalCollector{
-		target:   target,
-		username: username,
-		password: password,
+func newThermalCollectorWithClient(target string, client redfishClient) *ThermalCollector {
+	return newThermalCollectorWith(target, func(context.Context) (redfishClient, func(error), error) {
+		return client, func(error) {}, nil
+	})
+}
+
+func newThermalCollectorWith(target string, connect redfishConnector) *ThermalCollector {
+	thresholdLabels := []string{"sensor", "name", "chassis", "target"}
+	c := &ThermalCollector{
+		now:     time.Now,
+		connect: connect,
+		target:  target,
+		timeout: RedfishTimeout,
 		temperature: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "redfish_temperature_celsius",
+				Name: metricName("redfish_temperature_celsius"),
 				Help: "Temperature readings from Redfish",
 			},
-			[]string{"sensor", "name", "target", "health"},
+			[]string{"sensor", "name", "chassis", "target", "health"},
 		),
 		fanSpeed: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "redfish_fan_speed_rpm",
+				Name: metricName("redfish_fan_speed_rpm"),
 				Help: "Fan speeds from Redfish",
 			},
-			[]string{"fan", "name", "target", "health"},
+			[]string{"fan", "name", "chassis", "target", "health"},
+		),
+		tempHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_temperature_health"),
+				Help: "Temperature sensor health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical or other)",
+			},
+			[]string{"sensor", "name", "chassis", "target"},
+		),
+		upperCrit: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_temperature_upper_critical_celsius"),
+				Help: "Upper critical threshold of a temperature sensor from Redfish",
+			},
+			thresholdLabels,
+		),
+		upperWarn: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_temperature_upper_noncritical_celsius"),
+				Help: "Upper non-critical threshold of a temperature sensor from Redfish",
+			},
+			thresholdLabels,
 		),
+		lowerCrit: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_temperature_lower_critical_celsius"),
+				Help: "Lower critical threshold of a temperature sensor from Redfish",
+			},
+			thresholdLabels,
+		),
+		lowerWarn: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_temperature_lower_noncritical_celsius"),
+				Help: "Lower non-critical threshold of a temperature sensor from Redfish",
+			},
+			thresholdLabels,
+		),
+		fanHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_fan_health"),
+				Help: "Fan health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical or other)",
+			},
+			[]string{"fan", "name", "chassis", "target"},
+		),
+		fanMin: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_fan_speed_rpm_min"),
+				Help: "Lowest fan speed from Redfish since the previous scrape",
+			},
+			[]string{"fan", "name", "chassis", "target"},
+		),
+		fanMax: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_fan_speed_rpm_max"),
+				Help: "Highest fan speed from Redfish since the previous scrape",
+			},
+			[]string{"fan", "name", "chassis", "target"},
+		),
+		fanRanges: map[sensorKey]*fanRange{},
+		powerUsed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_power_consumed_watts"),
+				Help: "Power consumed from Redfish",
+			},
+			[]string{"name", "target"},
+		),
+		powerCap: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_power_capacity_watts"),
+				Help: "Power capacity from Redfish",
+			},
+			[]string{"name", "target"},
+		),
+		psuInput: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_psu_input_watts"),
+				Help: "Power supply input power from Redfish",
+			},
+			[]string{"psu", "name", "target", "model"},
+		),
+		psuOutput: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_psu_output_watts"),
+				Help: "Power supply output power from Redfish",
+			},
+			[]string{"psu", "name", "target", "model"},
+		),
+		psuHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_psu_health"),
+				Help: "Power supply health from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			[]string{"psu", "name", "target", "model"},
+		),
+		rsHealth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_power_redundancy_health"),
+				Help: "Health of the power supply redundancy set from Redfish (1 = OK, 0.5 = Warning, 0 = Critical)",
+			},
+			[]string{"target", "redundancy_set"},
+		),
+		rsEnabled: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_power_redundancy_enabled"),
+				Help: "Whether redundancy of the power supply redundancy set is enabled (1) or not (0)",
+			},
+			[]string{"target", "redundancy_set"},
+		),
+		sensor: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_sensor_reading"),
+				Help: "Readings of chassis sensors from Redfish that are neither temperatures nor fans, in the given unit",
+			},
+			[]string{"sensor", "unit", "chassis", "target"},
+		),
+		redfishUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName("redfish_up"),
+				Help: "Whether the last Redfish scrape succeeded (1) or not (0)",
+			},
+			[]string{"target"},
+		),
+		duration:   newScrapeDuration("thermal"),
+		lastScrape: newLastScrape("thermal"),
 	}
-
-	go collector.run()
-	return collector
+	c.runner = newRunner("thermal", c, scrapeMetrics{
+		duration:   c.duration,
+		lastScrape: c.lastScrape,
+	})
+	// Tests replace c.now, so look it up on every call
+	c.runner.now = func() time.Time { return c.now() }
+	return c
 }
 
 func (c *ThermalCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.temperature.Describe(ch)
 	c.fanSpeed.Describe(ch)
+	c.tempHealth.Describe(ch)
+	c.upperCrit.Describe(ch)
+	c.upperWarn.Describe(ch)
+	c.lowerCrit.Describe(ch)
+	c.lowerWarn.Describe(ch)
+	c.fanHealth.Describe(ch)
+	c.fanMin.Describe(ch)
+	c.fanMax.Describe(ch)
+	c.powerUsed.Describe(ch)
+	c.powerCap.Describe(ch)
+	c.psuInput.Describe(ch)
+	c.psuOutput.Describe(ch)
+	c.psuHealth.Describe(ch)
+	c.rsHealth.Describe(ch)
+	c.rsEnabled.Describe(ch)
+	c.sensor.Describe(ch)
+	c.redfishUp.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
 }
 
 func (c *ThermalCollector) Collect(ch chan<- prometheus.Metric) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.redfishUp.Reset()
+	up := 0.0
+	if c.up {
+		up = 1
+	}
+	c.redfishUp.WithLabelValues(c.target).Set(up)
+	if c.expired() {
+		// Frozen readings from a BMC that is gone are worse than none
+		c.cache = ThermalData{}
+	}
+
 	c.temperature.Reset()
+	c.tempHealth.Reset()
+	c.upperCrit.Reset()
+	c.upperWarn.Reset()
+	c.lowerCrit.Reset()
+	c.lowerWarn.Reset()
 	for _, temp := range c.cache.Temperatures {
-		c.temperature.WithLabelValues(temp.Name, "temperature", c.target, temp.Status.Health).Set(temp.ReadingCelsius)
+		if c.SkipUnknownHealth && unknownHealth(temp.Status.Health) {
+			continue
+		}
+		c.temperature.WithLabelValues(temp.Name, "temperature", temp.Chassis, c.target, temp.Status.Health).Set(temp.ReadingCelsius)
+		if v, ok := sensorHealthValue(temp.Status.Health); ok {
+			c.tempHealth.WithLabelValues(temp.Name, "temperature", temp.Chassis, c.target).Set(v)
+		}
+		for _, th := range []struct {
+			vec   *prometheus.GaugeVec
+			value float64
+		}{
+			{c.upperCrit, temp.UpperThresholdCritical},
+			{c.upperWarn, temp.UpperThresholdNonCritical},
+			{c.lowerCrit, temp.LowerThresholdCritical},
+			{c.lowerWarn, temp.LowerThresholdNonCritical},
+		} {
+			if th.value != 0 {
+				th.vec.WithLabelValues(temp.Name, "temperature", temp.Chassis, c.target).Set(th.value)
+			}
+		}
 	}
 
 	c.fanSpeed.Reset()
+	c.fanHealth.Reset()
+	c.fanMin.Reset()
+	c.fanMax.Reset()
 	for _, fan := range c.cache.Fans {
-		c.fanSpeed.WithLabelValues(fan.Name, "fan", c.target, fan.Status.Health).Set(fan.Reading)
+		if c.SkipUnknownHealth && unknownHealth(fan.Status.Health) {
+			continue
+		}
+		c.fanSpeed.WithLabelValues(fan.Name, "fan", fan.Chassis, c.target, fan.Status.Health).Set(fan.Reading)
+		if v, ok := sensorHealthValue(fan.Status.Health); ok {
+			c.fanHealth.WithLabelValues(fan.Name, "fan", fan.Chassis, c.target).Set(v)
+		}
+		if r, ok := c.fanRanges[sensorKey{fan.Chassis, fan.Name}]; ok {
+			c.fanMin.WithLabelValues(fan.Name, "fan", fan.Chassis, c.target).Set(r.min)
+			c.fanMax.WithLabelValues(fan.Name, "fan", fan.Chassis, c.target).Set(r.max)
+		}
+	}
+	// The next scrape window starts from the current readings
+	c.fanRanges = map[sensorKey]*fanRange{}
+	c.trackFans(c.cache.Fans)
+
+	c.powerUsed.Reset()
+	c.powerCap.Reset()
+	for _, pc := range c.cache.PowerControls {
+		if pc.ConsumedWatts > 0 {
+			c.powerUsed.WithLabelValues(pc.Name, c.target).Set(pc.ConsumedWatts)
+		}
+		if pc.CapacityWatts > 0 {
+			c.powerCap.WithLabelValues(pc.Name, c.target).Set(pc.CapacityWatts)
+		}
+	}
+
+	c.psuInput.Reset()
+	c.psuOutput.Reset()
+	c.psuHealth.Reset()
+	for _, psu := range c.cache.PowerSupplies {
+		psuLabels := []string{psu.ID, psu.Name, c.target, psu.Model}
+		if psu.InputWatts > 0 {
+			c.psuInput.WithLabelValues(psuLabels...).Set(psu.InputWatts)
+		}
+		if psu.OutputWatts > 0 {
+			c.psuOutput.WithLabelValues(psuLabels...).Set(psu.OutputWatts)
+		}
+		if v, ok := psuHealthValue(psu.Health); ok {
+			c.psuHealth.WithLabelValues(psuLabels...).Set(v)
+		}
+	}
+
+	c.rsHealth.Reset()
+	c.rsEnabled.Reset()
+	for _, r := range c.cache.Redundancies {
+		if v, ok := psuHealthValue(r.Health); ok {
+			c.rsHealth.WithLabelValues(c.target, r.Name).Set(v)
+		}
+		enabled := 0.0
+		if r.Enabled {
+			enabled = 1
+		}
+		c.rsEnabled.WithLabelValues(c.target, r.Name).Set(enabled)
+	}
+
+	c.sensor.Reset()
+	for _, sensor := range c.cache.Sensors {
+		c.sensor.WithLabelValues(sensor.Name, sensor.Unit, sensor.Chassis, c.target).Set(sensor.Reading)
 	}
 
 	c.temperature.Collect(ch)
 	c.fanSpeed.Collect(ch)
+	c.tempHealth.Collect(ch)
+	c.upperCrit.Collect(ch)
+	c.upperWarn.Collect(ch)
+	c.lowerCrit.Collect(ch)
+	c.lowerWarn.Collect(ch)
+	c.fanHealth.Collect(ch)
+	c.fanMin.Collect(ch)
+	c.fanMax.Collect(ch)
+	c.powerUsed.Collect(ch)
+	c.powerCap.Collect(ch)
+	c.psuInput.Collect(ch)
+	c.psuOutput.Collect(ch)
+	c.psuHealth.Collect(ch)
+	c.rsHealth.Collect(ch)
+	c.rsEnabled.Collect(ch)
+	c.sensor.Collect(ch)
+	c.redfishUp.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
 }
 
-func (c *ThermalCollector) run() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// psuHealthValue maps a Redfish health to 1 for OK, 0.5 for Warning and 0
+// for Critical, the encoding of every redfish_*_health gauge. ok is false
+// when no health is reported, e.g. for an empty PSU bay.
+func psuHealthValue(health string) (value float64, ok bool) {
+	switch common.Health(health) {
+	case common.OKHealth:
+		return 1, true
+	case common.WarningHealth:
+		return 0.5, true
+	case common.CriticalHealth:
+		return 0, true
+	}
+	return 0, false
+}
 
-	for {
-		c.fetch()
-		<-ticker.C
+// sensorHealthValue maps a temperature or fan health like psuHealthValue,
+// except that any other reported value, such as Unknown, maps to 0. ok is
+// false only when no health is reported at all.
+func sensorHealthValue(health string) (value float64, ok bool) {
+	if health == "" {
+		return 0, false
 	}
+	value, _ = psuHealthValue(health)
+	return value, true
+}
+
+// unknownHealth reports whether a sensor health value carries no information.
+func unknownHealth(health string) bool {
+	return health == "" || strings.EqualFold(health, "Unknown")
+}
+
+// Ready reports whether a fetch has succeeded at least once.
+func (c *ThermalCollector) Ready() bool {
+	return c.runner.Ready()
 }
 
-func (c *ThermalCollector) fetch() {
+// Health summarizes the fetches so far.
+func (c *ThermalCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It waits for a fetch in progress to
+// finish and must be called at most once.
+func (c *ThermalCollector) Stop() {
+	c.runner.Stop()
+}
+
+func (c *ThermalCollector) fetch(ctx context.Context) error {
+	// The timeout keeps a half-dead BMC from stalling the loop
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
 	// Use gofish to fetch thermal data
-	cfg := gofish.ClientConfig{
-		Endpoint:              "https://" + c.target,
-		Username:              c.username,
-		Password:              c.password,
-		Insecure:              true, // Set to false if you want to verify SSL certificates
-		MaxConcurrentRequests: 3,
-		ReuseConnections:      true,
-	}
-	client, err := gofish.Connect(cfg)
+	client, done, err := c.connect(ctx)
 	if err != nil {
-		log.Printf("Error connecting to Redfish target: %v", err)
-		return
+		c.setDown()
+		return fmt.Errorf("connecting to Redfish target: %w", err)
 	}
-	defer client.Logout()
-	service := client.Service
 
-	chass, err := service.Chassis()
+	chass, err := client.Chassis()
 	if err != nil {
-		log.Printf("Error fetching chassis: %v", err)
-		return
+		done(err)
+		c.setDown()
+		return fmt.Errorf("fetching chassis: %w", err)
 	}
+	// Sensors of every chassis are collected before the cache is replaced
+	var data ThermalData
 	for _, ch := range chass {
-		if therm, err := ch.Thermal(); err != nil || therm == nil {
-			continue
-		}
 		therm, err := ch.Thermal()
 		if err != nil {
 			log.Printf("Error fetching thermal data for chassis %s: %v", ch.Name, err)
+		}
+		power, err := ch.Power()
+		if err != nil {
+			log.Printf("Error fetching power data for chassis %s: %v", ch.Name, err)
+		}
+		sensors, err := ch.Sensors()
+		if err != nil {
+			log.Printf("Error fetching sensors for chassis %s: %v", ch.Name, err)
+		}
+		data.Sensors = append(data.Sensors, environmentSensors(ch.Name, sensors)...)
+		if therm != nil {
+			for _, temp := range therm.Temperatures {
+				data.Temperatures = append(data.Temperatures, TemperatureReading{
+					Name:                      temp.Name,
+					Chassis:                   ch.Name,
+					ReadingCelsius:            float64(temp.ReadingCelsius),
+					UpperThresholdCritical:    float64(temp.UpperThresholdCritical),
+					UpperThresholdNonCritical: float64(temp.UpperThresholdNonCritical),
+					LowerThresholdCritical:    float64(temp.LowerThresholdCritical),
+					LowerThresholdNonCritical: float64(temp.LowerThresholdNonCritical),
+					Status:                    SensorStatus{Health: string(temp.Status.Health)},
+				})
+			}
+			for _, fan := range therm.Fans {
+				data.Fans = append(data.Fans, FanReading{
+					Name:    fan.Name,
+					Chassis: ch.Name,
+					Reading: float64(fan.Reading),
+					Status:  SensorStatus{Health: string(fan.Status.Health)},
+				})
+			}
+		}
+		if power != nil {
+			for _, pc := range power.PowerControl {
+				name := pc.Name
+				if name == "" {
+					name = pc.MemberID
+				}
+				data.PowerControls = append(data.PowerControls, PowerControl{
+					Name:          name,
+					ConsumedWatts: float64(pc.PowerConsumedWatts),
+					CapacityWatts: float64(pc.PowerCapacityWatts),
+				})
+			}
+			for _, ps := range power.PowerSupplies {
+				output := ps.PowerOutputWatts
+				if output == 0 {
+					output = ps.LastPowerOutputWatts
+				}
+				data.PowerSupplies = append(data.PowerSupplies, PowerSupply{
+					ID:          ps.MemberID,
+					Name:        ps.Name,
+					Model:       ps.Model,
+					InputWatts:  float64(ps.PowerInputWatts),
+					OutputWatts: float64(output),
+					Health:      string(ps.Status.Health),
+				})
+			}
+			for _, r := range power.Redundancy {
+				name := r.Name
+				if name == "" {
+					name = r.MemberID
+				}
+				data.Redundancies = append(data.Redundancies, PowerRedundancy{
+					Name:    name,
+					Enabled: r.RedundancyEnabled,
+					Health:  string(r.Status.Health),
+				})
+			}
+		}
+	}
+
+	// Requests failed part way, so the readings are incomplete
+	if err := ctx.Err(); err != nil {
+		done(err)
+		c.setDown()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %v", c.timeout)
+		}
+		return err
+	}
+	done(nil)
+
+	c.mutex.Lock()
+	c.smoothTemperatures(data.Temperatures)
+	c.cache = data
+	c.trackFans(data.Fans)
+	c.up = true
+	c.lastSuccess = c.now()
+	c.mutex.Unlock()
+	return nil
+}
+
+// expired reports whether the cached readings must no longer be served.
+// The caller must hold the mutex.
+func (c *ThermalCollector) expired() bool {
+	return (!c.up && c.StaleAfter > 0 && c.now().Sub(c.lastSuccess) > c.StaleAfter) || c.runner.stale()
+}
+
+// PowerWatts returns the power consumed by all chassis of the target, as
+// last read from their power controls.
+func (c *ThermalCollector) PowerWatts() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.expired() {
+		return 0
+	}
+	total := 0.0
+	for _, pc := range c.cache.PowerControls {
+		total += pc.ConsumedWatts
+	}
+	return total
+}
+
+// environmentSensors returns the readings of the sensors of a chassis that
+// the Thermal resource does not already cover, e.g. humidity or airflow.
+// Absent sensors are skipped.
+func environmentSensors(chassis string, sensors []*redfish.Sensor) []SensorReading {
+	var readings []SensorReading
+	for _, sensor := range sensors {
+		switch sensor.ReadingType {
+		case redfish.TemperatureReadingType, redfish.RotationalReadingType:
 			continue
 		}
-		// unmarshal therm.Entries to ThermalData using mapstruct
-		data := ThermalData{
-			Temperatures: make([]struct {
-				Name           string  `json:"Name"`
-				ReadingCelsius float64 `json:"ReadingCelsius"`
-				Status         struct {
-					Health string `json:"Health"`
-				} `json:"Status"`
-			}, 0),
-			Fans: make([]struct {
-				Name    string  `json:"Name"`
-				Reading float64 `json:"Reading"`
-				Status  struct {
-					Health string `json:"Health"`
-				} `json:"Status"`
-			}, 0),
-		}
-		for _, temp := range therm.Temperatures {
-			data.Temperatures = append(data.Temperatures, struct {
-				Name           string  `json:"Name"`
-				ReadingCelsius float64 `json:"ReadingCelsius"`
-				Status         struct {
-					Health string `json:"Health"`
-				} `json:"Status"`
-			}{
-				Name:           temp.Name,
-				ReadingCelsius: float64(temp.ReadingCelsius),
-				Status: struct {
-					Health string `json:"Health"`
-				}{Health: string(temp.Status.Health)},
-			})
-		}
-		for _, fan := range therm.Fans {
-			data.Fans = append(data.Fans, struct {
-				Name    string  `json:"Name"`
-				Reading float64 `json:"Reading"`
-				Status  struct {
-					Health string `json:"Health"`
-				} `json:"Status"`
-			}{
-				Name:    fan.Name,
-				Reading: float64(fan.Reading),
-				Status: struct {
-					Health string `json:"Health"`
-				}{Health: string(fan.Status.Health)},
-			})
-		}
-		c.mutex.Lock()
-		c.cache = data
-		c.mutex.Unlock()
+		if sensor.Status.State == common.AbsentState {
+			continue
+		}
+		unit := sensor.ReadingUnits
+		if unit == "" {
+			unit = string(sensor.ReadingType)
+		}
+		readings = append(readings, SensorReading{
+			Name:    sensor.Name,
+			Chassis: chassis,
+			Unit:    unit,
+			Reading: float64(sensor.Reading),
+		})
 	}
+	return readings
+}
+
+// sensorKey identifies a fan or temperature sensor across fetches.
+type sensorKey struct {
+	chassis string
+	name    string
+}
+
+// fanRange is the lowest and highest speed read from a fan.
+type fanRange struct {
+	min, max float64
+}
+
+// trackFans widens the ranges of the given fans by their readings. The
+// caller must hold the mutex.
+func (c *ThermalCollector) trackFans(fans []FanReading) {
+	for _, fan := range fans {
+		key := sensorKey{fan.Chassis, fan.Name}
+		r, ok := c.fanRanges[key]
+		if !ok {
+			c.fanRanges[key] = &fanRange{min: fan.Reading, max: fan.Reading}
+			continue
+		}
+		r.min = min(r.min, fan.Reading)
+		r.max = max(r.max, fan.Reading)
+	}
+}
+
+// smoothTemperatures replaces the given readings by their moving averages
+// if Smoothing is on. Sensors that are no longer reported are forgotten. The
+// caller must hold the mutex.
+func (c *ThermalCollector) smoothTemperatures(temps []TemperatureReading) {
+	if c.Smoothing <= 0 {
+		return
+	}
+	smoothed := make(map[sensorKey]float64, len(temps))
+	for i := range temps {
+		t := &temps[i]
+		key := sensorKey{t.Chassis, t.Name}
+		if prev, ok := c.smoothed[key]; ok {
+			t.ReadingCelsius = c.Smoothing*t.ReadingCelsius + (1-c.Smoothing)*prev
+		}
+		smoothed[key] = t.ReadingCelsius
+	}
+	c.smoothed = smoothed
+}
+
+// setDown records a failed fetch. The cache is kept until it becomes stale.
+func (c *ThermalCollector) setDown() {
+	c.mutex.Lock()
+	c.up = false
+	c.mutex.Unlock()
 }
@@ -0,0 +1,369 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stmcginnis/gofish/common"
+	"github.com/stmcginnis/gofish/redfish"
+	"github.com/stretchr/testify/assert"
+)
+
+const mixedHealthThermal = `{
+	"Temperatures": [
+		{"Name": "CPU1 Temp", "ReadingCelsius": 45, "Status": {"Health": "OK"}},
+		{"Name": "CPU2 Temp", "ReadingCelsius": 0, "Status": {"Health": "Unknown"}},
+		{"Name": "GPU Temp", "ReadingCelsius": 0, "Status": {}}
+	],
+	"Fans": [
+		{"Name": "Fan1", "Reading": 4200, "Status": {"Health": "OK"}},
+		{"Name": "Fan2", "Reading": 0, "Status": {"Health": ""}}
+	]
+}`
+
+func TestThermalCollectorSkipUnknownHealth(t *testing.T) {
+	col := newThermalCollector(NewRedfishSession("127.0.0.1:1", "", ""))
+	col.mutex.Lock()
+	assert.NoError(t, json.Unmarshal([]byte(mixedHealthThermal), &col.cache))
+	col.mutex.Unlock()
+
+	assert.Equal(t, 5, testutil.CollectAndCount(col, "redfish_temperature_celsius", "redfish_fan_speed_rpm"))
+
+	col.SkipUnknownHealth = true
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "redfish_temperature_celsius", "redfish_fan_speed_rpm"))
+	assert.Equal(t, 45.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "", "127.0.0.1:1", "OK")))
+}
+
+func TestThermalCollectorSensorHealth(t *testing.T) {
+	col := newThermalCollector(NewRedfishSession("127.0.0.1:1", "", ""))
+	col.mutex.Lock()
+	assert.NoError(t, json.Unmarshal([]byte(mixedHealthThermal), &col.cache))
+	col.mutex.Unlock()
+
+	// Sensors reporting no health at all get no health series
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "redfish_temperature_health", "redfish_fan_health"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.tempHealth.WithLabelValues("CPU1 Temp", "temperature", "", "127.0.0.1:1")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.tempHealth.WithLabelValues("CPU2 Temp", "temperature", "", "127.0.0.1:1")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.fanHealth.WithLabelValues("Fan1", "fan", "", "127.0.0.1:1")))
+}
+
+// chassisResources is a single chassis reporting thermal and power data.
+func chassisResources() map[string]string {
+	return map[string]string{
+		"/redfish/v1/Chassis": `{"Members": [{"@odata.id": "/redfish/v1/Chassis/1"}]}`,
+		"/redfish/v1/Chassis/1": `{
+			"@odata.id": "/redfish/v1/Chassis/1", "Id": "1", "Name": "Chassis",
+			"Thermal": {"@odata.id": "/redfish/v1/Chassis/1/Thermal"},
+			"Power": {"@odata.id": "/redfish/v1/Chassis/1/Power"}
+		}`,
+		"/redfish/v1/Chassis/1/Thermal": `{
+			"Id": "Thermal",
+			"Temperatures": [{"MemberId": "0", "Name": "CPU1 Temp", "ReadingCelsius": 52, "UpperThresholdNonCritical": 85, "UpperThresholdCritical": 95, "Status": {"Health": "OK"}}],
+			"Fans": [{"MemberId": "0", "Name": "Fan1", "Reading": 3600, "Status": {"Health": "OK"}}]
+		}`,
+		"/redfish/v1/Chassis/1/Power": `{
+			"Id": "Power",
+			"PowerControl": [{"MemberId": "0", "Name": "System Power Control", "PowerConsumedWatts": 182, "PowerCapacityWatts": 750}],
+			"PowerSupplies": [
+				{"MemberId": "0", "Name": "PS1", "Model": "PWS-751P", "PowerInputWatts": 98, "PowerOutputWatts": 90, "Status": {"State": "Enabled", "Health": "OK"}},
+				{"MemberId": "1", "Name": "PS2", "Model": "PWS-751P", "PowerInputWatts": 94, "LastPowerOutputWatts": 86, "Status": {"State": "Enabled", "Health": "Warning"}}
+			],
+			"Redundancy": [{"MemberId": "0", "Name": "PSU Redundancy", "Mode": "N+m", "RedundancyEnabled": true, "Status": {"State": "Enabled", "Health": "Warning"}}]
+		}`,
+	}
+}
+
+func TestThermalCollectorPower(t *testing.T) {
+	target := newRedfishMock(t, chassisResources())
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	col.fetch(context.Background())
+
+	assert.Equal(t, 21, testutil.CollectAndCount(col))
+	assert.Equal(t, 182.0, testutil.ToFloat64(col.powerUsed.WithLabelValues("System Power Control", target)))
+	assert.Equal(t, 750.0, testutil.ToFloat64(col.powerCap.WithLabelValues("System Power Control", target)))
+
+	assert.Equal(t, 98.0, testutil.ToFloat64(col.psuInput.WithLabelValues("0", "PS1", target, "PWS-751P")))
+	assert.Equal(t, 90.0, testutil.ToFloat64(col.psuOutput.WithLabelValues("0", "PS1", target, "PWS-751P")))
+	assert.Equal(t, 86.0, testutil.ToFloat64(col.psuOutput.WithLabelValues("1", "PS2", target, "PWS-751P")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.psuHealth.WithLabelValues("0", "PS1", target, "PWS-751P")))
+	assert.Equal(t, 0.5, testutil.ToFloat64(col.psuHealth.WithLabelValues("1", "PS2", target, "PWS-751P")))
+	// One PSU is degraded, so the set is no longer fully redundant
+	assert.Equal(t, 0.5, testutil.ToFloat64(col.rsHealth.WithLabelValues(target, "PSU Redundancy")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.rsEnabled.WithLabelValues(target, "PSU Redundancy")))
+}
+
+func TestThermalCollectorSensors(t *testing.T) {
+	resources := chassisResources()
+	resources["/redfish/v1/Chassis/1"] = `{
+		"@odata.id": "/redfish/v1/Chassis/1", "Id": "1", "Name": "Chassis",
+		"Sensors": {"@odata.id": "/redfish/v1/Chassis/1/Sensors"}
+	}`
+	resources["/redfish/v1/Chassis/1/Sensors"] = `{"Members": [
+		{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Humidity"},
+		{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Airflow"},
+		{"@odata.id": "/redfish/v1/Chassis/1/Sensors/CPU1Temp"},
+		{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Absent"}
+	]}`
+	resources["/redfish/v1/Chassis/1/Sensors/Humidity"] = `{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Humidity", "Id": "Humidity", "Name": "Inlet Humidity", "ReadingType": "Humidity", "ReadingUnits": "%", "Reading": 41}`
+	// No unit reported, so the reading type stands in
+	resources["/redfish/v1/Chassis/1/Sensors/Airflow"] = `{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Airflow", "Id": "Airflow", "Name": "System Airflow", "ReadingType": "AirFlow", "Reading": 38.5}`
+	// Covered by the Thermal resource
+	resources["/redfish/v1/Chassis/1/Sensors/CPU1Temp"] = `{"@odata.id": "/redfish/v1/Chassis/1/Sensors/CPU1Temp", "Id": "CPU1Temp", "Name": "CPU1 Temp", "ReadingType": "Temperature", "ReadingUnits": "Cel", "Reading": 52}`
+	resources["/redfish/v1/Chassis/1/Sensors/Absent"] = `{"@odata.id": "/redfish/v1/Chassis/1/Sensors/Absent", "Id": "Absent", "Name": "Outlet Humidity", "ReadingType": "Humidity", "ReadingUnits": "%", "Status": {"State": "Absent"}}`
+	target := newRedfishMock(t, resources)
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := fmt.Sprintf(`
+# HELP redfish_sensor_reading Readings of chassis sensors from Redfish that are neither temperatures nor fans, in the given unit
+# TYPE redfish_sensor_reading gauge
+redfish_sensor_reading{chassis="Chassis",sensor="Inlet Humidity",target=%[1]q,unit="%%"} 41
+redfish_sensor_reading{chassis="Chassis",sensor="System Airflow",target=%[1]q,unit="AirFlow"} 38.5
+`, target)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "redfish_sensor_reading"))
+}
+
+func TestThermalCollectorLabels(t *testing.T) {
+	target := newRedfishMock(t, chassisResources())
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := fmt.Sprintf(`
+# HELP redfish_fan_speed_rpm Fan speeds from Redfish
+# TYPE redfish_fan_speed_rpm gauge
+redfish_fan_speed_rpm{chassis="Chassis",fan="Fan1",health="OK",name="fan",target=%[1]q} 3600
+# HELP redfish_temperature_celsius Temperature readings from Redfish
+# TYPE redfish_temperature_celsius gauge
+redfish_temperature_celsius{chassis="Chassis",health="OK",name="temperature",sensor="CPU1 Temp",target=%[1]q} 52
+# HELP redfish_up Whether the last Redfish scrape succeeded (1) or not (0)
+# TYPE redfish_up gauge
+redfish_up{target=%[1]q} 1
+`, target)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected),
+		"redfish_temperature_celsius", "redfish_fan_speed_rpm", "redfish_up"))
+}
+
+func TestThermalCollectorTargetDown(t *testing.T) {
+	resources := chassisResources()
+	var down atomic.Bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1" {
+			w.Write([]byte(redfishServiceRoot))
+			return
+		}
+		w.Write([]byte(resources[path]))
+	}))
+	t.Cleanup(srv.Close)
+	target := strings.TrimPrefix(srv.URL, "https://")
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	// redfish_up is set when the collector is collected
+	assert.NoError(t, col.runner.scrape())
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+
+	// Readings are kept while the BMC is down, and redfish_up recovers with it
+	down.Store(true)
+	assert.Error(t, col.runner.scrape())
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+	down.Store(false)
+	assert.NoError(t, col.runner.scrape())
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+}
+
+func TestThermalCollectorMultipleChassis(t *testing.T) {
+	resources := chassisResources()
+	resources["/redfish/v1/Chassis"] = `{"Members": [{"@odata.id": "/redfish/v1/Chassis/1"}, {"@odata.id": "/redfish/v1/Chassis/2"}]}`
+	resources["/redfish/v1/Chassis/2"] = `{"@odata.id": "/redfish/v1/Chassis/2", "Id": "2", "Name": "Enclosure", "Thermal": {"@odata.id": "/redfish/v1/Chassis/2/Thermal"}}`
+	// Same sensor name as on the first chassis
+	resources["/redfish/v1/Chassis/2/Thermal"] = `{
+		"Id": "Thermal",
+		"Temperatures": [{"MemberId": "0", "Name": "CPU1 Temp", "ReadingCelsius": 38, "Status": {"Health": "OK"}}]
+	}`
+	target := newRedfishMock(t, resources)
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	col.fetch(context.Background())
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	assert.Equal(t, 52.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Chassis", target, "OK")))
+	assert.Equal(t, 38.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Enclosure", target, "OK")))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_fan_speed_rpm"))
+}
+
+func TestThermalCollectorFanRange(t *testing.T) {
+	target := newRedfishMock(t, chassisResources())
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	assert.NoError(t, col.fetch(context.Background()))
+	// A surge read by a fetch in between is gone by the next one
+	col.mutex.Lock()
+	col.trackFans([]FanReading{{Name: "Fan1", Chassis: "Chassis", Reading: 5200}})
+	col.mutex.Unlock()
+
+	expected := `
+# HELP redfish_fan_speed_rpm_max Highest fan speed from Redfish since the previous scrape
+# TYPE redfish_fan_speed_rpm_max gauge
+redfish_fan_speed_rpm_max{chassis="Chassis",fan="Fan1",name="fan",target="%[1]s"} %[2]v
+# HELP redfish_fan_speed_rpm_min Lowest fan speed from Redfish since the previous scrape
+# TYPE redfish_fan_speed_rpm_min gauge
+redfish_fan_speed_rpm_min{chassis="Chassis",fan="Fan1",name="fan",target="%[1]s"} 3600
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(expected, target, 5200)), "redfish_fan_speed_rpm_min", "redfish_fan_speed_rpm_max"))
+	// The range restarts from the current reading after every scrape
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(expected, target, 3600)), "redfish_fan_speed_rpm_min", "redfish_fan_speed_rpm_max"))
+}
+
+func TestThermalCollectorSmoothing(t *testing.T) {
+	col := newThermalCollector(NewRedfishSession(newRedfishMock(t, chassisResources()), "", ""))
+	col.Smoothing = 0.25
+	col.mutex.Lock()
+	col.smoothTemperatures([]TemperatureReading{{Name: "CPU1 Temp", Chassis: "Chassis", ReadingCelsius: 60}})
+	col.mutex.Unlock()
+
+	// The fetched 52°C only moves the average a quarter of the way
+	assert.NoError(t, col.fetch(context.Background()))
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 58.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Chassis", col.target, "OK")))
+
+	col.Smoothing = 0
+	assert.NoError(t, col.fetch(context.Background()))
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 52.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Chassis", col.target, "OK")))
+}
+
+func TestThermalCollectorUp(t *testing.T) {
+	// Nothing listens on port 1, so the initial fetch fails
+	col := newThermalCollector(NewRedfishSession("127.0.0.1:1", "", ""))
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.redfishUp.WithLabelValues("127.0.0.1:1")))
+
+	target := newRedfishMock(t, chassisResources())
+	col = newThermalCollector(NewRedfishSession(target, "", ""))
+	now := time.Now()
+	col.now = func() time.Time { return now }
+	col.StaleAfter = 5 * time.Minute
+	assert.NoError(t, col.runner.scrape())
+
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(col.lastScrape))
+
+	// Readings survive a failed fetch until they are older than StaleAfter
+	col.setDown()
+	now = now.Add(4 * time.Minute)
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+}
+
+func TestProbeThermal(t *testing.T) {
+	target := newRedfishMock(t, chassisResources())
+
+	col := ProbeThermal(target, "", "")
+	defer col.Stop()
+
+	assert.Equal(t, 21, testutil.CollectAndCount(col))
+	assert.Equal(t, 52.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Chassis", target, "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+}
+
+func TestThermalCollectorThresholds(t *testing.T) {
+	target := newRedfishMock(t, chassisResources())
+
+	col := ProbeThermal(target, "", "")
+	defer col.Stop()
+
+	// Thresholds the BMC does not report are omitted
+	assert.Equal(t, 2, testutil.CollectAndCount(col,
+		"redfish_temperature_upper_critical_celsius", "redfish_temperature_upper_noncritical_celsius",
+		"redfish_temperature_lower_critical_celsius", "redfish_temperature_lower_noncritical_celsius"))
+	assert.Equal(t, 95.0, testutil.ToFloat64(col.upperCrit.WithLabelValues("CPU1 Temp", "temperature", "Chassis", target)))
+	assert.Equal(t, 85.0, testutil.ToFloat64(col.upperWarn.WithLabelValues("CPU1 Temp", "temperature", "Chassis", target)))
+}
+
+func TestThermalCollectorTimeout(t *testing.T) {
+	resources := chassisResources()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if path == "/redfish/v1" {
+			w.Write([]byte(redfishServiceRoot))
+			return
+		}
+		if path == "/redfish/v1/Chassis/1/Thermal" {
+			// A half-dead BMC never answers
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(resources[path]))
+	}))
+	t.Cleanup(srv.Close)
+	target := strings.TrimPrefix(srv.URL, "https://")
+
+	col := newThermalCollector(NewRedfishSession(target, "", ""))
+	col.timeout = 100 * time.Millisecond
+	assert.ErrorContains(t, col.fetch(context.Background()), "timed out")
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.redfishUp.WithLabelValues(target)))
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "redfish_temperature_celsius", "redfish_power_consumed_watts"))
+}
+
+// fakeRedfish serves the chassis of canned Redfish JSON keyed by path
+// without a server.
+type fakeRedfish struct {
+	common.Client
+	resources map[string]string
+	err       error
+}
+
+func (f *fakeRedfish) Get(url string) (*http.Response, error) {
+	body, ok := f.resources[url]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", url)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func (f *fakeRedfish) Chassis() ([]*redfish.Chassis, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return redfish.ListReferencedChassis(f, "/redfish/v1/Chassis")
+}
+
+func TestThermalCollectorWithClient(t *testing.T) {
+	client := &fakeRedfish{resources: chassisResources()}
+	col := newThermalCollectorWithClient("bmc1", client)
+	assert.NoError(t, col.fetch(context.Background()))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "redfish_temperature_celsius"))
+
+	assert.Equal(t, 52.0, testutil.ToFloat64(col.temperature.WithLabelValues("CPU1 Temp", "temperature", "Chassis", "bmc1", "OK")))
+	assert.Equal(t, 3600.0, testutil.ToFloat64(col.fanSpeed.WithLabelValues("Fan1", "fan", "Chassis", "bmc1", "OK")))
+	assert.Equal(t, 182.0, testutil.ToFloat64(col.powerUsed.WithLabelValues("System Power Control", "bmc1")))
+
+	client.err = errors.New("connection refused")
+	assert.ErrorIs(t, col.fetch(context.Background()), client.err)
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.redfishUp.WithLabelValues("bmc1")))
+}
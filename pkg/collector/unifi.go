@@ -1,7 +1,13 @@
 package collector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,35 +15,121 @@ import (
 	unifi "github.com/unpoller/unifi/v5"
 )
 
+// Debugf is used for verbose logging. It discards everything unless replaced,
+// e.g. with log.Printf.
+var Debugf = func(format string, v ...interface{}) {}
+
+// UniFiLoginAttempts is how often a fetch tries to log in before giving up.
+// Collectors read it when they are created.
+var UniFiLoginAttempts = 3
+
+// UniFiSites limits the UniFi collector to the sites with these names or
+// descriptions. Empty means all sites. Collectors read it when they are
+// created.
+var UniFiSites []string
+
+// UniFiDPI enables fetching per-client DPI statistics, which costs a
+// request per site. Collectors read it when they are created.
+var UniFiDPI bool
+
+// UniFiIDS enables fetching the IDS/IPS alarms of the controller, which costs
+// a request per site. Collectors read it when they are created.
+var UniFiIDS bool
+
+// UniFiSiteIDLabels fills the site label with the ID of the site rather than
+// its name, which changes when the site is renamed. Collectors read it when
+// they are created.
+var UniFiSiteIDLabels bool
+
+// UniFiKeepMissingDevices keeps exporting unifi_device_state 0 for devices
+// that the controller stopped listing, e.g. after it forgot an offline
+// device, so that alerts on the device going down keep firing. Devices are
+// remembered until the exporter restarts. Collectors read it when they are
+// created.
+var UniFiKeepMissingDevices bool
+
+// UniFiMinimalPortLabels drops the source and uplink labels from the port
+// metrics. Collectors read it when they are created.
+var UniFiMinimalPortLabels bool
+
 type UnifiData struct {
 	Sites   []unifi.Site
 	Devices UnifiDevices
 	Clients []unifi.Client
+	// DPI holds the per-client application traffic, if enabled
+	DPI []unifi.DPITable
+	// IDSAlarms holds the threat alarms raised by IDS/IPS, if enabled
+	IDSAlarms []unifi.Alarm
+	// UnknownDevices counts devices by type that have no adapter.
+	UnknownDevices map[string]int
 }
 
 type UnifiDevice interface {
 	Name() string
 	Site() string
 	IP() string
-	HasTemperature() bool
-	Temperature() float64
+	// Temperatures returns the sensors of the device, or nil if it reports
+	// none.
+	Temperatures() []DeviceTemperature
 	Model() string
 	Type() string
 	CPUUsage() float64
 	MEMUsage() float64
+	LoadAverage() (load1, load5, load15 float64)
+	State() int
+	Version() string
+	Mac() string
+	Uptime() float64
+	Adopted() bool
+	// Uplink returns the link to the upstream device, or nil if the device
+	// reports none.
+	Uplink() *DeviceUplink
+}
+
+// UniFi device states as reported by the controller.
+const (
+	stateDisconnected = 0
+	stateConnected    = 1
+	statePending      = 2
+	stateUpgrading    = 4
+	stateProvisioning = 5
+	stateHeartbeat    = 6
+	stateAdopting     = 7
+)
+
+// isProvisioning reports whether a device is transiently unavailable while
+// the controller provisions or adopts it.
+func isProvisioning(state int) bool {
+	return state == stateProvisioning || state == stateAdopting
+}
+
+// DeviceUplink is the wired or wireless link of a device to the device
+// upstream of it, e.g. of an AP to its switch.
+type DeviceUplink struct {
+	Mac        string // of the upstream device
+	SpeedMbps  float64
+	FullDuplex bool
+	Up         bool
+}
+
+// DeviceTemperature is a named temperature sensor of a device, e.g. the CPU
+// of a UDM.
+type DeviceTemperature struct {
+	Sensor  string
+	Celsius float64
 }
 
 type udmAdapter struct{ *unifi.UDM }
 
-func (d udmAdapter) Name() string         { return d.UDM.Name }
-func (d udmAdapter) Site() string         { return d.UDM.SiteName }
-func (d udmAdapter) IP() string           { return d.UDM.IP }
-func (d udmAdapter) HasTemperature() bool { return d.UDM.HasTemperature.Val }
-func (d udmAdapter) Temperature() float64 {
-	if len(d.UDM.Temperatures) > 0 {
-		return float64(d.UDM.Temperatures[0].Value)
+func (d udmAdapter) Name() string { return d.UDM.Name }
+func (d udmAdapter) Site() string { return d.UDM.SiteName }
+func (d udmAdapter) IP() string   { return d.UDM.IP }
+func (d udmAdapter) Temperatures() []DeviceTemperature {
+	var temps []DeviceTemperature
+	for _, t := range d.UDM.Temperatures {
+		temps = append(temps, DeviceTemperature{Sensor: t.Name, Celsius: t.Value})
 	}
-	return 0
+	return temps
 }
 func (d udmAdapter) Model() string { return d.UDM.Model }
 func (d udmAdapter) Type() string  { return "UDM" }
@@ -53,16 +145,26 @@ func (d udmAdapter) MEMUsage() float64 {
 	}
 	return d.UDM.SystemStats.Mem.Val
 }
+func (d udmAdapter) LoadAverage() (load1, load5, load15 float64) {
+	return d.UDM.SysStats.Loadavg1.Val, d.UDM.SysStats.Loadavg5.Val, d.UDM.SysStats.Loadavg15.Val
+}
+func (d udmAdapter) State() int      { return d.UDM.State.Int() }
+func (d udmAdapter) Version() string { return d.UDM.Version }
+func (d udmAdapter) Mac() string     { return d.UDM.Mac }
+func (d udmAdapter) Uptime() float64 { return d.UDM.Uptime.Val }
+func (d udmAdapter) Adopted() bool   { return d.UDM.Adopted.Val }
+
+// Uplink returns nil, as the uplink of a gateway is its WAN.
+func (d udmAdapter) Uplink() *DeviceUplink { return nil }
 
 type usgAdapter struct{ *unifi.USG }
 
-func (d usgAdapter) Name() string         { return d.USG.Name }
-func (d usgAdapter) Site() string         { return d.USG.SiteName }
-func (d usgAdapter) IP() string           { return d.USG.IP }
-func (d usgAdapter) HasTemperature() bool { return false }
-func (d usgAdapter) Temperature() float64 { return 0 }
-func (d usgAdapter) Model() string        { return d.USG.Model }
-func (d usgAdapter) Type() string         { return "USG" }
+func (d usgAdapter) Name() string                      { return d.USG.Name }
+func (d usgAdapter) Site() string                      { return d.USG.SiteName }
+func (d usgAdapter) IP() string                        { return d.USG.IP }
+func (d usgAdapter) Temperatures() []DeviceTemperature { return nil }
+func (d usgAdapter) Model() string                     { return d.USG.Model }
+func (d usgAdapter) Type() string                      { return "USG" }
 func (d usgAdapter) CPUUsage() float64 {
 	if d.USG.SystemStats.CPU.Val < 0 {
 		return 0
@@ -75,16 +177,31 @@ func (d usgAdapter) MEMUsage() float64 {
 	}
 	return d.USG.SystemStats.Mem.Val
 }
+func (d usgAdapter) LoadAverage() (load1, load5, load15 float64) {
+	return d.USG.SysStats.Loadavg1.Val, d.USG.SysStats.Loadavg5.Val, d.USG.SysStats.Loadavg15.Val
+}
+func (d usgAdapter) State() int      { return d.USG.State.Int() }
+func (d usgAdapter) Version() string { return d.USG.Version }
+func (d usgAdapter) Mac() string     { return d.USG.Mac }
+func (d usgAdapter) Uptime() float64 { return d.USG.Uptime.Val }
+func (d usgAdapter) Adopted() bool   { return d.USG.Adopted.Val }
+
+// Uplink returns nil, as the uplink of a gateway is its WAN.
+func (d usgAdapter) Uplink() *DeviceUplink { return nil }
 
 type uswAdapter struct{ *unifi.USW }
 
-func (d uswAdapter) Name() string         { return d.USW.Name }
-func (d uswAdapter) Site() string         { return d.USW.SiteName }
-func (d uswAdapter) IP() string           { return d.USW.IP }
-func (d uswAdapter) HasTemperature() bool { return d.USW.HasTemperature.Val }
-func (d uswAdapter) Temperature() float64 { return d.USW.GeneralTemperature.Val }
-func (d uswAdapter) Model() string        { return d.USW.Model }
-func (d uswAdapter) Type() string         { return "USW" }
+func (d uswAdapter) Name() string { return d.USW.Name }
+func (d uswAdapter) Site() string { return d.USW.SiteName }
+func (d uswAdapter) IP() string   { return d.USW.IP }
+func (d uswAdapter) Temperatures() []DeviceTemperature {
+	if !d.USW.HasTemperature.Val {
+		return nil
+	}
+	return []DeviceTemperature{{Sensor: "general", Celsius: d.USW.GeneralTemperature.Val}}
+}
+func (d uswAdapter) Model() string { return d.USW.Model }
+func (d uswAdapter) Type() string  { return "USW" }
 func (d uswAdapter) CPUUsage() float64 {
 	if d.USW.SystemStats.CPU.Val < 0 {
 		return 0
@@ -97,16 +214,35 @@ func (d uswAdapter) MEMUsage() float64 {
 	}
 	return d.USW.SystemStats.Mem.Val
 }
+func (d uswAdapter) LoadAverage() (load1, load5, load15 float64) {
+	return d.USW.SysStats.Loadavg1.Val, d.USW.SysStats.Loadavg5.Val, d.USW.SysStats.Loadavg15.Val
+}
+func (d uswAdapter) State() int      { return d.USW.State.Int() }
+func (d uswAdapter) Version() string { return d.USW.Version }
+func (d uswAdapter) Mac() string     { return d.USW.Mac }
+func (d uswAdapter) Uptime() float64 { return d.USW.Uptime.Val }
+func (d uswAdapter) Adopted() bool   { return d.USW.Adopted.Val }
+func (d uswAdapter) Uplink() *DeviceUplink {
+	// The switch at the root of the network has no upstream device
+	if d.USW.LastUplink.UplinkMac == "" {
+		return nil
+	}
+	return &DeviceUplink{
+		Mac:        d.USW.LastUplink.UplinkMac,
+		SpeedMbps:  d.USW.Uplink.Speed.Val,
+		FullDuplex: d.USW.Uplink.FullDuplex.Val,
+		Up:         d.USW.Uplink.Up.Val,
+	}
+}
 
 type uapAdapter struct{ *unifi.UAP }
 
-func (d uapAdapter) Name() string         { return d.UAP.Name }
-func (d uapAdapter) Site() string         { return d.UAP.SiteName }
-func (d uapAdapter) IP() string           { return d.UAP.IP }
-func (d uapAdapter) HasTemperature() bool { return false } // most UAPs don't report temperature
-func (d uapAdapter) Temperature() float64 { return 0 }
-func (d uapAdapter) Model() string        { return d.UAP.Model }
-func (d uapAdapter) Type() string         { return "UAP" }
+func (d uapAdapter) Name() string                      { return d.UAP.Name }
+func (d uapAdapter) Site() string                      { return d.UAP.SiteName }
+func (d uapAdapter) IP() string                        { return d.UAP.IP }
+func (d uapAdapter) Temperatures() []DeviceTemperature { return nil } // most UAPs don't report temperature
+func (d uapAdapter) Model() string                     { return d.UAP.Model }
+func (d uapAdapter) Type() string                      { return "UAP" }
 func (d uapAdapter) CPUUsage() float64 {
 	if d.UAP.SystemStats.CPU.Val < 0 {
 		return 0
@@ -119,27 +255,88 @@ func (d uapAdapter) MEMUsage() float64 {
 	}
 	return d.UAP.SystemStats.Mem.Val
 }
+func (d uapAdapter) LoadAverage() (load1, load5, load15 float64) {
+	return d.UAP.SysStats.Loadavg1.Val, d.UAP.SysStats.Loadavg5.Val, d.UAP.SysStats.Loadavg15.Val
+}
+func (d uapAdapter) State() int      { return d.UAP.State.Int() }
+func (d uapAdapter) Version() string { return d.UAP.Version }
+func (d uapAdapter) Mac() string     { return d.UAP.Mac }
+func (d uapAdapter) Uptime() float64 { return d.UAP.Uptime.Val }
+func (d uapAdapter) Adopted() bool   { return d.UAP.Adopted.Val }
+func (d uapAdapter) Uplink() *DeviceUplink {
+	if d.UAP.Uplink.UplinkMac == "" {
+		return nil
+	}
+	return &DeviceUplink{
+		Mac:        d.UAP.Uplink.UplinkMac,
+		SpeedMbps:  d.UAP.Uplink.Speed.Val,
+		FullDuplex: d.UAP.Uplink.FullDuplex.Val,
+		Up:         d.UAP.Uplink.Up.Val,
+	}
+}
+
+// ClientCount returns the number of stations connected to the AP, users and
+// guests combined.
+func (d uapAdapter) ClientCount() float64 { return d.UAP.NumSta.Val }
+
+type pduAdapter struct{ *unifi.PDU }
+
+func (d pduAdapter) Name() string                      { return d.PDU.Name }
+func (d pduAdapter) Site() string                      { return d.PDU.SiteName }
+func (d pduAdapter) IP() string                        { return d.PDU.IP }
+func (d pduAdapter) Temperatures() []DeviceTemperature { return nil }
+func (d pduAdapter) Model() string                     { return d.PDU.Model }
+func (d pduAdapter) Type() string                      { return "PDU" }
+func (d pduAdapter) CPUUsage() float64 {
+	if d.PDU.SystemStats.CPU.Val < 0 {
+		return 0
+	}
+	return d.PDU.SystemStats.CPU.Val
+}
+func (d pduAdapter) MEMUsage() float64 {
+	if d.PDU.SystemStats.Mem.Val < 0 {
+		return 0
+	}
+	return d.PDU.SystemStats.Mem.Val
+}
+func (d pduAdapter) LoadAverage() (load1, load5, load15 float64) {
+	return d.PDU.SysStats.Loadavg1.Val, d.PDU.SysStats.Loadavg5.Val, d.PDU.SysStats.Loadavg15.Val
+}
+func (d pduAdapter) State() int      { return d.PDU.State.Int() }
+func (d pduAdapter) Version() string { return d.PDU.Version }
+func (d pduAdapter) Mac() string     { return d.PDU.Mac }
+func (d pduAdapter) Uptime() float64 { return d.PDU.Uptime.Val }
+func (d pduAdapter) Adopted() bool   { return d.PDU.Adopted.Val }
+
+// Uplink returns nil, as the PDU reports no upstream device mac.
+func (d pduAdapter) Uplink() *DeviceUplink { return nil }
 
 type UnifiDevices struct {
 	UDMs []unifi.UDM
 	USGs []unifi.USG
 	USWs []unifi.USW
 	UAPs []unifi.UAP
+	PDUs []unifi.PDU
 }
 
 func (d UnifiDevices) All() []UnifiDevice {
 	var all []UnifiDevice
-	for _, dev := range d.UDMs {
-		all = append(all, udmAdapter{&dev})
+	// Point at the slice elements rather than the loop variable, which older
+	// Go versions reuse across iterations.
+	for i := range d.UDMs {
+		all = append(all, udmAdapter{&d.UDMs[i]})
+	}
+	for i := range d.USGs {
+		all = append(all, usgAdapter{&d.USGs[i]})
 	}
-	for _, dev := range d.USGs {
-		all = append(all, usgAdapter{&dev})
+	for i := range d.USWs {
+		all = append(all, uswAdapter{&d.USWs[i]})
 	}
-	for _, dev := range d.USWs {
-		all = append(all, uswAdapter{&dev})
+	for i := range d.UAPs {
+		all = append(all, uapAdapter{&d.UAPs[i]})
 	}
-	for _, dev := range d.UAPs {
-		all = append(all, uapAdapter{&dev})
+	for i := range d.PDUs {
+		all = append(all, pduAdapter{&d.PDUs[i]})
 	}
 	return all
 }
@@ -148,6 +345,8 @@ type UniFiClient interface {
 	GetSites() ([]*unifi.Site, error)
 	GetClients([]*unifi.Site) ([]*unifi.Client, error)
 	GetDevices([]*unifi.Site) (*unifi.Devices, error)
+	GetClientsDPI([]*unifi.Site) ([]*unifi.DPITable, error)
+	GetAlarms([]*unifi.Site) ([]*unifi.Alarm, error)
 	Login() error
 }
 
@@ -155,77 +354,304 @@ type UniFiCollector struct {
 	client UniFiClient
 	mutex  sync.Mutex
 	cache  UnifiData
+	runner *runner
+	now    func() time.Time
+	// Login retries wait loginBackoff, doubling after each failed attempt
+	loginAttempts int
+	loginBackoff  time.Duration
+	// sites is the site allowlist, nil for all sites
+	sites map[string]bool
+	// dpi enables fetching per-client DPI statistics
+	dpi bool
+	// ids enables fetching the IDS/IPS alarms
+	ids bool
+	// minimalPortLabels drops the source and uplink port labels
+	minimalPortLabels bool
+	// siteIDs maps site names to the IDs that replace them in the site
+	// label. It is nil unless UniFiSiteIDLabels is set.
+	siteIDs    map[string]string
+	useSiteIDs bool
+	// knownDevices holds every device listed since the exporter started. It
+	// is nil unless UniFiKeepMissingDevices is set.
+	knownDevices map[deviceKey]bool
+	// poeEnergy integrates PoE power per port across scrapes, keyed by
+	// switch MAC and port index.
+	poeEnergy map[string]*poeMeter
 	// Device metrics
 	deviceTemp *prometheus.GaugeVec
 	deviceCPU  *prometheus.GaugeVec
 	deviceMem  *prometheus.GaugeVec
-	// Switch metrics for usw
-	swRXPackets *prometheus.CounterVec // d.Stat.Sw.RxPackets
-	swRXBytes   *prometheus.CounterVec // d.Stat.Sw.RxBytes
-	swRXErrors  *prometheus.CounterVec // d.Stat.Sw.RxErrors
-	swRXDropped *prometheus.CounterVec // d.Stat.Sw.RxDropped
-	swTXPackets *prometheus.CounterVec // d.Stat.Sw.TxPackets
-	swTXBytes   *prometheus.CounterVec // d.Stat.Sw.TxBytes
-	swTXErrors  *prometheus.CounterVec // d.Stat.Sw.TxErrors
-	swTXDropped *prometheus.CounterVec // d.Stat.Sw.TxDropped
-	swBytes     *prometheus.CounterVec // d.Stat.Sw.Bytes
+	// Load averages from d.SysStats
+	deviceLoad1  *prometheus.GaugeVec
+	deviceLoad5  *prometheus.GaugeVec
+	deviceLoad15 *prometheus.GaugeVec
+	// deviceProvisioning is 1 while a device is being provisioned or adopted
+	deviceProvisioning *prometheus.GaugeVec
+	// deviceInfo is always 1, carrying model and firmware as labels
+	deviceInfo   *prometheus.GaugeVec
+	deviceUptime *prometheus.GaugeVec
+	// deviceState is 1 while a device is connected to the controller
+	deviceState   *prometheus.GaugeVec
+	deviceAdopted *prometheus.GaugeVec
+	uplinkSpeed   *prometheus.GaugeVec // dev.Uplink().SpeedMbps
+	uplinkUp      *prometheus.GaugeVec // dev.Uplink().Up
+	deviceClients *prometheus.GaugeVec // clients in the cache per device they connect through
+	// Switch metrics for usw. Like all UniFi counters, the switch counters
+	// are emitted as const metrics with the controller's cumulative value,
+	// so they are not part of resetAll.
+	swRXPackets *prometheus.Desc     // d.Stat.Sw.RxPackets
+	swRXBytes   *prometheus.Desc     // d.Stat.Sw.RxBytes
+	swRXErrors  *prometheus.Desc     // d.Stat.Sw.RxErrors
+	swRXDropped *prometheus.Desc     // d.Stat.Sw.RxDropped
+	swTXPackets *prometheus.Desc     // d.Stat.Sw.TxPackets
+	swTXBytes   *prometheus.Desc     // d.Stat.Sw.TxBytes
+	swTXErrors  *prometheus.Desc     // d.Stat.Sw.TxErrors
+	swTXDropped *prometheus.Desc     // d.Stat.Sw.TxDropped
+	swBytes     *prometheus.Desc     // d.Stat.Sw.Bytes
+	swPoEBudget *prometheus.GaugeVec // d.TotalMaxPower
+	swPoEUsed   *prometheus.GaugeVec // sum of d.PortTable[i].PoePower
 	// Port metrics for usw and udm
-	pRXPackets *prometheus.CounterVec // d.PortTable[i].RxPackets
-	pRXBytes   *prometheus.CounterVec // d.PortTable[i].RxBytes
-	pRXErrors  *prometheus.CounterVec // d.PortTable[i].RxErrors
-	pRXDropped *prometheus.CounterVec // d.PortTable[i].RxDropped
-	pSpeed     *prometheus.GaugeVec   // d.PortTable[i].Speed
-	pTXPackets *prometheus.CounterVec // d.PortTable[i].TxPackets
-	pTXBytes   *prometheus.CounterVec // d.PortTable[i].TxBytes
-	pTXErrors  *prometheus.CounterVec // d.PortTable[i].TxErrors
-	pTXDropped *prometheus.CounterVec // d.PortTable[i].TxDropped
-	pSFPTemp   *prometheus.GaugeVec   // if SFPFound.Val -> d.PortTable[i].SFPTemp
+	pUp        *prometheus.GaugeVec // d.PortTable[i].Up
+	pSTPState  *prometheus.GaugeVec // d.PortTable[i].StpState
+	pRXPackets *prometheus.Desc     // d.PortTable[i].RxPackets
+	pRXBytes   *prometheus.Desc     // d.PortTable[i].RxBytes
+	pRXErrors  *prometheus.Desc     // d.PortTable[i].RxErrors
+	pRXDropped *prometheus.Desc     // d.PortTable[i].RxDropped
+	pSpeed     *prometheus.GaugeVec // d.PortTable[i].Speed
+	pTXPackets *prometheus.Desc     // d.PortTable[i].TxPackets
+	pTXBytes   *prometheus.Desc     // d.PortTable[i].TxBytes
+	pTXErrors  *prometheus.Desc     // d.PortTable[i].TxErrors
+	pTXDropped *prometheus.Desc     // d.PortTable[i].TxDropped
+	pSFPTemp   *prometheus.GaugeVec // if SFPFound.Val -> d.PortTable[i].SFPTemp
+	pSFPRx     *prometheus.GaugeVec // if SFPFound.Val -> d.PortTable[i].SFPRxpower
+	pSFPTx     *prometheus.GaugeVec // if SFPFound.Val -> d.PortTable[i].SFPTxpower
+	pSFPVolt   *prometheus.GaugeVec // if SFPFound.Val -> d.PortTable[i].SFPVoltage
+	pPoEPower  *prometheus.GaugeVec // if PoeEnable.Val -> d.PortTable[i].PoePower
+	pPoEEnergy *prometheus.Desc     // if PoeEnable.Val -> integral of d.PortTable[i].PoePower
+	// WAN metrics for udm and usg
+	wanRXBytes *prometheus.Desc     // d.Wan1/Wan2.RxBytes
+	wanTXBytes *prometheus.Desc     // d.Wan1/Wan2.TxBytes
+	wanRate    *prometheus.GaugeVec // d.Wan1/Wan2.BytesR
+	wanUptime  *prometheus.GaugeVec // d.Uplink.Uptime of the active WAN
+	wanInfo    *prometheus.GaugeVec // d.GeoInfo[WAN, WAN2].IspName
+	// Latest WAN speed test of udm and usg, if one has run
+	speedtestDown    *prometheus.GaugeVec // d.SpeedtestStatus.XputDownload
+	speedtestUp      *prometheus.GaugeVec // d.SpeedtestStatus.XputUpload
+	speedtestLatency *prometheus.GaugeVec // d.SpeedtestStatus.Latency
+	// Storage metrics for udm
+	udmStorageUsed  *prometheus.GaugeVec // d.Storage[i].Used
+	udmStorageTotal *prometheus.GaugeVec // d.Storage[i].Size
+	// AP metrics for uap
+	apClients           *prometheus.GaugeVec // d.NumSta
+	ssidChannelWidth    *prometheus.GaugeVec // d.RadioTable[i].Ht for the radio of d.VapTable[j]
+	bandSteeringClients *prometheus.GaugeVec // d.VapTable[j].NumSta on 5GHz with band steering on
+	ssidClients         *prometheus.GaugeVec // d.VapTable[j].NumSta
+	// Radio metrics for uap
+	radioChannel     *prometheus.GaugeVec // d.RadioTableStats[i].Channel
+	radioTxPower     *prometheus.GaugeVec // d.RadioTableStats[i].TxPower
+	radioUtilization *prometheus.GaugeVec // d.RadioTableStats[i].CuTotal
+	radioClients     *prometheus.GaugeVec // d.RadioTableStats[i].NumSta
+	radioTxRetries   *prometheus.GaugeVec // d.RadioTableStats[i].TxRetries / TxPackets
+	// Outlet metrics for pdu
+	outletPower   *prometheus.GaugeVec // d.OutletTable[i].OutletPower
+	outletCurrent *prometheus.GaugeVec // d.OutletTable[i].OutletCurrent
+	outletVoltage *prometheus.GaugeVec // d.OutletTable[i].OutletVoltage
+	outletRelay   *prometheus.GaugeVec // d.OutletTable[i].RelayState
+	// Client metrics
+	clientRssi *prometheus.GaugeVec // c.Rssi, wireless clients only
+	// c.Satisfaction, wireless clients only
+	clientSatisfaction *prometheus.GaugeVec
+	clientUptime       *prometheus.GaugeVec // c.Uptime
+	clientLastSeen     *prometheus.GaugeVec // c.LastSeen
+	// Client byte counters, const metrics as well
+	clientTXBytes *prometheus.Desc // c.TxBytes
+	clientRXBytes *prometheus.Desc // c.RxBytes
+	// DPI byte counters per client and application, const metrics as well
+	dpiTXBytes *prometheus.Desc // dpi.ByApp[i].TxBytes
+	dpiRXBytes *prometheus.Desc // dpi.ByApp[i].RxBytes
+	// Active IDS/IPS alarms per gateway and category
+	idsAlarms *prometheus.GaugeVec
+	// Site metrics
+	siteDevices      *prometheus.GaugeVec // devices in the cache per site
+	siteClients      *prometheus.GaugeVec // clients in the cache per site
+	siteWANStatus    *prometheus.GaugeVec // s.Health[wan].Status == "ok"
+	siteDisconnected *prometheus.GaugeVec // sum of s.Health[i].NumDisconnected
+	// Devices returned by the controller without a matching adapter
+	unknownDevices *prometheus.GaugeVec
+	// Counter values that could not be represented exactly as float64
+	precisionLoss prometheus.Counter
+	// Whether the last fetch succeeded, and failed controller calls
+	up           prometheus.Gauge
+	scrapeErrors prometheus.Counter
+	// Duration of the last fetch and time of the last successful one
+	duration   prometheus.Gauge
+	lastScrape prometheus.Gauge
 	// Removed for now
 	/*
 		portTx        *prometheus.GaugeVec
 		uplinkRxBytes *prometheus.GaugeVec
 		uplinkTxBytes *prometheus.GaugeVec
-		clientRssi    *prometheus.GaugeVec
-		apClients     *prometheus.GaugeVec
 		radioRxBytes  *prometheus.GaugeVec
 		radioTxBytes  *prometheus.GaugeVec
 	*/
 }
 
 func NewUniFiCollectorWithClient(client UniFiClient) *UniFiCollector {
+	col := newUniFiCollectorWithClient(client)
+	col.runner.start()
+	return col
+}
+
+func newUniFiCollectorWithClient(client UniFiClient) *UniFiCollector {
 	labels := []string{"type", "site", "source", "name"}
-	portLabels := []string{"type", "site", "source", "name", "port", "port_number", "up", "uplink"}
+	// type is the kind of device, e.g. UDM, like on every other metric
+	modelLabels := []string{"type", "site", "source", "name", "model"}
+	// The link state is exported as unifi_port_up rather than as a label, so
+	// that the counters of a port keep their series when it flaps
+	portLabels := []string{"type", "site", "source", "name", "port", "port_number", "uplink"}
+	if UniFiMinimalPortLabels {
+		portLabels = []string{"type", "site", "name", "port", "port_number"}
+	}
+	ssidLabels := []string{"essid", "ap_mac", "radio"}
+	radioLabels := []string{"site", "name", "radio", "radio_name"}
+	outletLabels := []string{"site", "name", "outlet", "outlet_index"}
+	wanLabels := []string{"site", "name", "wan", "ip"}
+	siteLabels := []string{"site", "desc"}
+	switchLabels := []string{"site", "name"}
+	gatewayLabels := []string{"site", "name"}
+	storageLabels := []string{"site", "name", "storage", "mount_point"}
+	// The network lets client metrics be aggregated per VLAN
+	clientLabels := []string{"site", "name", "mac", "network"}
 	col := &UniFiCollector{
-		client:     client,
-		deviceTemp: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_device_temperature_celsius", Help: "Device temp (°C)"}, labels),
-		deviceCPU:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_device_cpu_pct", Help: "Device CPU (%)"}, labels),
-		deviceMem:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_device_mem_pct", Help: "Device memory (%)"}, labels),
+		client:    client,
+		now:       time.Now,
+		poeEnergy: map[string]*poeMeter{},
+
+		loginAttempts: UniFiLoginAttempts,
+		loginBackoff:  time.Second,
+		sites:         siteSet(UniFiSites),
+		dpi:           UniFiDPI,
+		ids:           UniFiIDS,
+
+		minimalPortLabels: UniFiMinimalPortLabels,
+		useSiteIDs:        UniFiSiteIDLabels,
+
+		deviceTemp:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_temperature_celsius"), Help: "Device temp (°C)"}, append(modelLabels, "sensor")),
+		deviceCPU:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_cpu_pct"), Help: "Device CPU (%)"}, modelLabels),
+		deviceMem:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_mem_pct"), Help: "Device memory (%)"}, modelLabels),
+		deviceLoad1:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_load1"), Help: "Device 1m load average"}, labels),
+		deviceLoad5:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_load5"), Help: "Device 5m load average"}, labels),
+		deviceLoad15: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_load15"), Help: "Device 15m load average"}, labels),
+
+		deviceProvisioning: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_provisioning"), Help: "Device is being provisioned or adopted (1) or not (0)"}, labels),
+		deviceInfo:         prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_info"), Help: "Device model and firmware version"}, []string{"type", "site", "name", "model", "version", "mac"}),
+		deviceUptime:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_uptime_seconds"), Help: "Device uptime (s)"}, labels),
+		deviceState:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_state"), Help: "Whether the device is connected to the controller (1) or not (0)"}, []string{"type", "site", "name"}),
+		deviceAdopted:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_adopted"), Help: "Whether the device is adopted by the controller (1) or not (0)"}, []string{"type", "site", "name"}),
+		uplinkSpeed:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_uplink_speed_mbps"), Help: "Negotiated speed of the link to the upstream device (Mbps)"}, []string{"site", "name", "uplink_mac", "full_duplex"}),
+		uplinkUp:           prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_uplink_up"), Help: "Whether the link to the upstream device is up (1) or not (0)"}, []string{"site", "name", "uplink_mac"}),
+		deviceClients:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_device_connected_clients"), Help: "Clients connected through the device, wireless ones by AP and wired ones by switch"}, []string{"type", "site", "name", "mac"}),
 		// Switch metrics for usw
-		swRXPackets: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_rx_packets_total", Help: "Switch RX packets"}, labels),
-		swRXBytes:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_rx_bytes_total", Help: "Switch RX bytes"}, labels),
-		swRXErrors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_rx_errors_total", Help: "Switch RX errors"}, labels),
-		swRXDropped: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_rx_dropped_total", Help: "Switch RX dropped"}, labels),
-		swTXPackets: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_tx_packets_total", Help: "Switch TX packets"}, labels),
-		swTXBytes:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_tx_bytes_total", Help: "Switch TX bytes"}, labels),
-		swTXErrors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_tx_errors_total", Help: "Switch TX errors"}, labels),
-		swTXDropped: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_tx_dropped_total", Help: "Switch TX dropped"}, labels),
-		swBytes:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_switch_bytes_total", Help: "Switch total bytes"}, labels),
+		swRXPackets: prometheus.NewDesc(metricName("unifi_switch_rx_packets_total"), "Switch RX packets", labels, nil),
+		swRXBytes:   prometheus.NewDesc(metricName("unifi_switch_rx_bytes_total"), "Switch RX bytes", labels, nil),
+		swRXErrors:  prometheus.NewDesc(metricName("unifi_switch_rx_errors_total"), "Switch RX errors", labels, nil),
+		swRXDropped: prometheus.NewDesc(metricName("unifi_switch_rx_dropped_total"), "Switch RX dropped", labels, nil),
+		swTXPackets: prometheus.NewDesc(metricName("unifi_switch_tx_packets_total"), "Switch TX packets", labels, nil),
+		swTXBytes:   prometheus.NewDesc(metricName("unifi_switch_tx_bytes_total"), "Switch TX bytes", labels, nil),
+		swTXErrors:  prometheus.NewDesc(metricName("unifi_switch_tx_errors_total"), "Switch TX errors", labels, nil),
+		swTXDropped: prometheus.NewDesc(metricName("unifi_switch_tx_dropped_total"), "Switch TX dropped", labels, nil),
+		swBytes:     prometheus.NewDesc(metricName("unifi_switch_bytes_total"), "Switch total bytes", labels, nil),
+		swPoEBudget: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_switch_poe_budget_watts"), Help: "Switch total PoE power budget (W)"}, switchLabels),
+		swPoEUsed:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_switch_poe_used_watts"), Help: "Switch total PoE power draw (W)"}, switchLabels),
 
 		// Port metrics for usw and udm
-		pRXPackets: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_rx_packets_total", Help: "Port RX packets"}, portLabels),
-		pRXBytes:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_rx_bytes_total", Help: "Port RX bytes"}, portLabels),
-		pRXErrors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_rx_errors_total", Help: "Port RX errors"}, portLabels),
-		pRXDropped: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_rx_dropped_total", Help: "Port RX dropped"}, portLabels),
-		pSpeed:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_port_speed_bps", Help: "Port speed (bps)"}, portLabels),
-		pTXPackets: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_tx_packets_total", Help: "Port TX packets"}, portLabels),
-		pTXBytes:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_tx_bytes_total", Help: "Port TX bytes"}, portLabels),
-		pTXErrors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_tx_errors_total", Help: "Port TX errors"}, portLabels),
-		pTXDropped: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "unifi_port_tx_dropped_total", Help: "Port TX dropped"}, portLabels),
-		pSFPTemp:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "unifi_port_sfp_temperature_celsius", Help: "Port SFP temperature (°C)"}, portLabels),
+		pUp:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_up"), Help: "Whether the port link is up (1) or not (0)"}, portLabels),
+		pSTPState:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_stp_state"), Help: "Spanning tree state of the port: 1 disabled, 2 blocking, 3 listening, 4 learning, 5 forwarding, 6 broken"}, portLabels),
+		pRXPackets: prometheus.NewDesc(metricName("unifi_port_rx_packets_total"), "Port RX packets", portLabels, nil),
+		pRXBytes:   prometheus.NewDesc(metricName("unifi_port_rx_bytes_total"), "Port RX bytes", portLabels, nil),
+		pRXErrors:  prometheus.NewDesc(metricName("unifi_port_rx_errors_total"), "Port RX errors", portLabels, nil),
+		pRXDropped: prometheus.NewDesc(metricName("unifi_port_rx_dropped_total"), "Port RX dropped", portLabels, nil),
+		pSpeed:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_speed_bps"), Help: "Port speed (bps)"}, portLabels),
+		pTXPackets: prometheus.NewDesc(metricName("unifi_port_tx_packets_total"), "Port TX packets", portLabels, nil),
+		pTXBytes:   prometheus.NewDesc(metricName("unifi_port_tx_bytes_total"), "Port TX bytes", portLabels, nil),
+		pTXErrors:  prometheus.NewDesc(metricName("unifi_port_tx_errors_total"), "Port TX errors", portLabels, nil),
+		pTXDropped: prometheus.NewDesc(metricName("unifi_port_tx_dropped_total"), "Port TX dropped", portLabels, nil),
+		pSFPTemp:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_sfp_temperature_celsius"), Help: "Port SFP temperature (°C)"}, portLabels),
+		pSFPRx:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_sfp_rx_power_dbm"), Help: "Port SFP received optical power (dBm)"}, portLabels),
+		pSFPTx:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_sfp_tx_power_dbm"), Help: "Port SFP transmitted optical power (dBm)"}, portLabels),
+		pSFPVolt:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_sfp_voltage_volts"), Help: "Port SFP supply voltage (V)"}, portLabels),
+		pPoEPower:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_port_poe_power_watts"), Help: "Port PoE power draw (W)"}, append(portLabels, "poe_mode")),
+		pPoEEnergy: prometheus.NewDesc(metricName("unifi_port_poe_energy_kwh"), "Port PoE energy integrated from power readings since exporter start (kWh)", portLabels, nil),
+
+		// WAN metrics for udm and usg
+		wanRXBytes: prometheus.NewDesc(metricName("unifi_wan_rx_bytes_total"), "WAN RX bytes", wanLabels, nil),
+		wanTXBytes: prometheus.NewDesc(metricName("unifi_wan_tx_bytes_total"), "WAN TX bytes", wanLabels, nil),
+		wanRate:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_wan_rate_bytes_per_second"), Help: "WAN throughput, RX and TX combined (bytes/s)"}, wanLabels),
+		wanUptime:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_wan_uptime_seconds"), Help: "Time since the active WAN connection came up (s)"}, []string{"site", "name", "wan"}),
+		wanInfo:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_wan_info"), Help: "WAN address and the ISP detected for it, always 1"}, []string{"site", "name", "wan", "isp", "ip"}),
+
+		speedtestDown:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_speedtest_download_bps"), Help: "Download rate of the latest gateway speed test (bps)"}, gatewayLabels),
+		speedtestUp:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_speedtest_upload_bps"), Help: "Upload rate of the latest gateway speed test (bps)"}, gatewayLabels),
+		speedtestLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_speedtest_latency_ms"), Help: "Latency of the latest gateway speed test (ms)"}, gatewayLabels),
+
+		// Storage metrics for UDM
+		udmStorageUsed:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_udm_storage_used_bytes"), Help: "Used space of a UDM storage volume (bytes)"}, storageLabels),
+		udmStorageTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_udm_storage_total_bytes"), Help: "Size of a UDM storage volume (bytes)"}, storageLabels),
+
+		// AP metrics for uap
+		apClients:           prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_ap_clients"), Help: "Clients connected to the AP"}, labels),
+		ssidChannelWidth:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_ap_ssid_channel_width_mhz"), Help: "Channel width of the radio serving the SSID (MHz)"}, ssidLabels),
+		bandSteeringClients: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_ap_band_steering_clients"), Help: "Clients on the 5GHz radio of an SSID while band steering is enabled"}, ssidLabels),
+		// ssid is the VAP interface name, essid the network name clients see
+		ssidClients: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_ssid_clients"), Help: "Clients connected to an SSID on the AP"}, []string{"site", "name", "ssid", "essid", "radio"}),
+
+		// Radio metrics for uap
+		radioChannel:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_radio_channel"), Help: "Radio channel"}, radioLabels),
+		radioTxPower:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_radio_tx_power_dbm"), Help: "Radio TX power (dBm)"}, radioLabels),
+		radioUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_radio_channel_utilization_pct"), Help: "Radio channel utilization (%)"}, radioLabels),
+		radioClients:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_radio_clients"), Help: "Stations connected to the radio"}, radioLabels),
+		radioTxRetries:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_radio_tx_retries_pct"), Help: "Radio TX packets that had to be retried (%)"}, radioLabels),
+
+		// Outlet metrics for pdu
+		outletPower:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_outlet_power_watts"), Help: "PDU outlet power draw (W)"}, outletLabels),
+		outletCurrent: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_outlet_current_amps"), Help: "PDU outlet current (A)"}, outletLabels),
+		outletVoltage: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_outlet_voltage_volts"), Help: "PDU outlet voltage (V)"}, outletLabels),
+		outletRelay:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_outlet_relay_state"), Help: "Whether the PDU outlet relay is on (1) or off (0)"}, outletLabels),
+
+		// Client metrics
+		clientRssi:         prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_client_rssi_dbm"), Help: "Wireless client signal strength (dBm)"}, append(clientLabels, "ap_mac", "ssid")),
+		clientSatisfaction: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_client_satisfaction_pct"), Help: "Wireless client WiFi experience score (%)"}, append(clientLabels, "ap_mac")),
+		clientUptime:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_client_uptime_seconds"), Help: "Time since the client connected (s)"}, clientLabels),
+		clientLastSeen:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_client_last_seen_timestamp_seconds"), Help: "Time the controller last saw the client, as a Unix timestamp"}, clientLabels),
+		clientTXBytes:      prometheus.NewDesc(metricName("unifi_client_tx_bytes_total"), "Client TX bytes", clientLabels, nil),
+		dpiTXBytes:         prometheus.NewDesc(metricName("unifi_dpi_tx_bytes_total"), "Client TX bytes per DPI application", []string{"site", "app", "category", "client"}, nil),
+		dpiRXBytes:         prometheus.NewDesc(metricName("unifi_dpi_rx_bytes_total"), "Client RX bytes per DPI application", []string{"site", "app", "category", "client"}, nil),
+		idsAlarms:          prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_ids_alarms"), Help: "Threat alarms raised by IDS/IPS that the controller still lists"}, []string{"site", "name", "category"}),
+		clientRXBytes:      prometheus.NewDesc(metricName("unifi_client_rx_bytes_total"), "Client RX bytes", clientLabels, nil),
+
+		// Site metrics
+		siteDevices:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_site_devices"), Help: "Devices of the site"}, siteLabels),
+		siteClients:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_site_clients"), Help: "Clients connected to the site"}, siteLabels),
+		siteWANStatus:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_site_wan_status"), Help: "Whether the site's WAN health is ok (1) or not (0)"}, siteLabels),
+		siteDisconnected: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_site_devices_disconnected"), Help: "Adopted devices of the site that are disconnected"}, siteLabels),
+
+		unknownDevices: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: metricName("unifi_unknown_devices"), Help: "Devices of a type not handled by the exporter"}, []string{"type"}),
+		precisionLoss:  prometheus.NewCounter(prometheus.CounterOpts{Name: "home_lab_exporter_counter_precision_loss_total", Help: "Counter values exported with lost integer precision (beyond 2^53)"}),
+		up:             prometheus.NewGauge(prometheus.GaugeOpts{Name: metricName("unifi_up"), Help: "Whether the last UniFi fetch succeeded (1) or not (0)"}),
+		scrapeErrors:   prometheus.NewCounter(prometheus.CounterOpts{Name: metricName("unifi_scrape_errors_total"), Help: "Failed UniFi controller calls"}),
+		duration:       prometheus.NewGauge(prometheus.GaugeOpts{Name: metricName("unifi_scrape_duration_seconds"), Help: "Duration of the last UniFi fetch"}),
+		lastScrape:     prometheus.NewGauge(prometheus.GaugeOpts{Name: metricName("unifi_last_scrape_timestamp_seconds"), Help: "Unix time of the last successful UniFi fetch"}),
 	}
 
-	go col.run()
+	if UniFiKeepMissingDevices {
+		col.knownDevices = map[deviceKey]bool{}
+	}
 
+	col.runner = newRunner("unifi", col, scrapeMetrics{
+		up:         col.up,
+		duration:   prometheus.ObserverFunc(col.duration.Set),
+		lastScrape: col.lastScrape,
+	})
 	return col
 }
 
@@ -233,216 +659,1035 @@ func (c *UniFiCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.deviceTemp.Describe(ch)
 	c.deviceCPU.Describe(ch)
 	c.deviceMem.Describe(ch)
+	c.deviceLoad1.Describe(ch)
+	c.deviceLoad5.Describe(ch)
+	c.deviceLoad15.Describe(ch)
+	c.deviceProvisioning.Describe(ch)
+	c.deviceInfo.Describe(ch)
+	c.deviceUptime.Describe(ch)
+	c.deviceState.Describe(ch)
+	c.deviceAdopted.Describe(ch)
+	c.deviceClients.Describe(ch)
+	c.uplinkSpeed.Describe(ch)
+	c.uplinkUp.Describe(ch)
 	// Switch metrics
-	c.swRXPackets.Describe(ch)
-	c.swRXBytes.Describe(ch)
-	c.swRXErrors.Describe(ch)
-	c.swRXDropped.Describe(ch)
-	c.swTXPackets.Describe(ch)
-	c.swTXBytes.Describe(ch)
-	c.swTXErrors.Describe(ch)
-	c.swTXDropped.Describe(ch)
-	c.swBytes.Describe(ch)
+	ch <- c.swRXPackets
+	ch <- c.swRXBytes
+	ch <- c.swRXErrors
+	ch <- c.swRXDropped
+	ch <- c.swTXPackets
+	ch <- c.swTXBytes
+	ch <- c.swTXErrors
+	ch <- c.swTXDropped
+	ch <- c.swBytes
+	c.swPoEBudget.Describe(ch)
+	c.swPoEUsed.Describe(ch)
 	// Port metrics
-	c.pRXPackets.Describe(ch)
-	c.pRXBytes.Describe(ch)
-	c.pRXErrors.Describe(ch)
-	c.pRXDropped.Describe(ch)
+	c.pUp.Describe(ch)
+	c.pSTPState.Describe(ch)
+	ch <- c.pRXPackets
+	ch <- c.pRXBytes
+	ch <- c.pRXErrors
+	ch <- c.pRXDropped
 	c.pSpeed.Describe(ch)
-	c.pTXPackets.Describe(ch)
-	c.pTXBytes.Describe(ch)
-	c.pTXErrors.Describe(ch)
-	c.pTXDropped.Describe(ch)
+	ch <- c.pTXPackets
+	ch <- c.pTXBytes
+	ch <- c.pTXErrors
+	ch <- c.pTXDropped
 	c.pSFPTemp.Describe(ch)
+	c.pSFPRx.Describe(ch)
+	c.pSFPTx.Describe(ch)
+	c.pSFPVolt.Describe(ch)
+	c.pPoEPower.Describe(ch)
+	ch <- c.pPoEEnergy
+	ch <- c.wanRXBytes
+	ch <- c.wanTXBytes
+	c.wanRate.Describe(ch)
+	c.wanUptime.Describe(ch)
+	c.wanInfo.Describe(ch)
+	c.speedtestDown.Describe(ch)
+	c.speedtestUp.Describe(ch)
+	c.speedtestLatency.Describe(ch)
+	c.udmStorageUsed.Describe(ch)
+	c.udmStorageTotal.Describe(ch)
+	c.apClients.Describe(ch)
+	c.ssidChannelWidth.Describe(ch)
+	c.bandSteeringClients.Describe(ch)
+	c.ssidClients.Describe(ch)
+	c.radioChannel.Describe(ch)
+	c.radioTxPower.Describe(ch)
+	c.radioUtilization.Describe(ch)
+	c.radioClients.Describe(ch)
+	c.radioTxRetries.Describe(ch)
+	c.outletPower.Describe(ch)
+	c.outletCurrent.Describe(ch)
+	c.outletVoltage.Describe(ch)
+	c.outletRelay.Describe(ch)
+	c.clientRssi.Describe(ch)
+	c.clientSatisfaction.Describe(ch)
+	c.clientUptime.Describe(ch)
+	c.clientLastSeen.Describe(ch)
+	// Site metrics
+	c.siteDevices.Describe(ch)
+	c.siteClients.Describe(ch)
+	c.siteWANStatus.Describe(ch)
+	c.siteDisconnected.Describe(ch)
+	ch <- c.clientTXBytes
+	ch <- c.clientRXBytes
+	ch <- c.dpiTXBytes
+	ch <- c.dpiRXBytes
+	c.idsAlarms.Describe(ch)
+	c.unknownDevices.Describe(ch)
+	c.precisionLoss.Describe(ch)
+	c.up.Describe(ch)
+	c.scrapeErrors.Describe(ch)
+	c.duration.Describe(ch)
+	c.lastScrape.Describe(ch)
 }
 func (c *UniFiCollector) Collect(ch chan<- prometheus.Metric) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+
+	if c.runner.stale() {
+		c.cache = UnifiData{}
+	}
+	if c.useSiteIDs {
+		c.siteIDs = map[string]string{}
+		for _, s := range c.cache.Sites {
+			c.siteIDs[s.SiteName] = s.ID
+		}
+	}
 	// Reset all metrics before collecting new data
 	resetAll(c)
+	counters := counterSet{}
+
+	// Without sites the last fetch failed rather than devices disappearing
+	if c.knownDevices != nil && len(c.cache.Sites) > 0 {
+		c.collectMissingDevices()
+	}
+
+	clientsByMac := connectedClients(c.cache.Clients)
 
 	for _, dev := range c.cache.Devices.All() {
-		labelValues := []string{dev.Type(), dev.Site(), dev.IP(), dev.Name()}
-		c.deviceTemp.WithLabelValues(dev.Model(), dev.Site(), dev.IP(), dev.Name()).Set(dev.Temperature())
-		c.deviceCPU.WithLabelValues(dev.Model(), dev.Site(), dev.IP(), dev.Name()).Set(dev.CPUUsage())
-		c.deviceMem.WithLabelValues(dev.Model(), dev.Site(), dev.IP(), dev.Name()).Set(dev.MEMUsage())
+		labelValues := []string{dev.Type(), c.siteLabel(dev.Site()), dev.IP(), dev.Name()}
+		for _, t := range dev.Temperatures() {
+			c.deviceTemp.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.IP(), dev.Name(), dev.Model(), t.Sensor).Set(t.Celsius)
+		}
+		c.deviceCPU.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.IP(), dev.Name(), dev.Model()).Set(dev.CPUUsage())
+		c.deviceMem.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.IP(), dev.Name(), dev.Model()).Set(dev.MEMUsage())
+		load1, load5, load15 := dev.LoadAverage()
+		c.deviceLoad1.WithLabelValues(labelValues...).Set(load1)
+		c.deviceLoad5.WithLabelValues(labelValues...).Set(load5)
+		c.deviceLoad15.WithLabelValues(labelValues...).Set(load15)
+		provisioning := 0.0
+		if isProvisioning(dev.State()) {
+			provisioning = 1
+		}
+		c.deviceProvisioning.WithLabelValues(labelValues...).Set(provisioning)
+		c.deviceInfo.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.Name(), dev.Model(), dev.Version(), dev.Mac()).Set(1)
+		c.deviceUptime.WithLabelValues(labelValues...).Set(dev.Uptime())
+		connected, adopted := 0.0, 0.0
+		if dev.State() == stateConnected {
+			connected = 1
+		}
+		if dev.Adopted() {
+			adopted = 1
+		}
+		c.deviceState.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.Name()).Set(connected)
+		c.deviceAdopted.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.Name()).Set(adopted)
+		c.deviceClients.WithLabelValues(dev.Type(), c.siteLabel(dev.Site()), dev.Name(), dev.Mac()).Set(float64(clientsByMac[strings.ToLower(dev.Mac())]))
+		if uplink := dev.Uplink(); uplink != nil {
+			up := 0.0
+			if uplink.Up {
+				up = 1
+			}
+			c.uplinkSpeed.WithLabelValues(c.siteLabel(dev.Site()), dev.Name(), uplink.Mac, strconv.FormatBool(uplink.FullDuplex)).Set(uplink.SpeedMbps)
+			c.uplinkUp.WithLabelValues(c.siteLabel(dev.Site()), dev.Name(), uplink.Mac).Set(up)
+		}
 
 		// Switch metrics for USW
 		if usw, ok := dev.(uswAdapter); ok {
-			stat := usw.USW.Stat.Sw
-			c.swRXPackets.WithLabelValues(labelValues...).Add(float64(stat.RxPackets.Val))
-			c.swRXBytes.WithLabelValues(labelValues...).Add(float64(stat.RxBytes.Val))
-			c.swRXErrors.WithLabelValues(labelValues...).Add(float64(stat.RxErrors.Val))
-			c.swRXDropped.WithLabelValues(labelValues...).Add(float64(stat.RxDropped.Val))
-			c.swTXPackets.WithLabelValues(labelValues...).Add(float64(stat.TxPackets.Val))
-			c.swTXBytes.WithLabelValues(labelValues...).Add(float64(stat.TxBytes.Val))
-			c.swTXErrors.WithLabelValues(labelValues...).Add(float64(stat.TxErrors.Val))
-			c.swTXDropped.WithLabelValues(labelValues...).Add(float64(stat.TxDropped.Val))
-			c.swBytes.WithLabelValues(labelValues...).Add(float64(stat.Bytes.Val))
+			// Offline and newly adopted switches come without a stat block
+			if stat := usw.USW.Stat.Sw; stat != nil {
+				counters.add(c.swRXPackets, stat.RxPackets.Val, labelValues...)
+				counters.add(c.swRXBytes, stat.RxBytes.Val, labelValues...)
+				counters.add(c.swRXErrors, stat.RxErrors.Val, labelValues...)
+				counters.add(c.swRXDropped, stat.RxDropped.Val, labelValues...)
+				counters.add(c.swTXPackets, stat.TxPackets.Val, labelValues...)
+				counters.add(c.swTXBytes, stat.TxBytes.Val, labelValues...)
+				counters.add(c.swTXErrors, stat.TxErrors.Val, labelValues...)
+				counters.add(c.swTXDropped, stat.TxDropped.Val, labelValues...)
+				counters.add(c.swBytes, stat.Bytes.Val, labelValues...)
+			}
 
 			// Port metrics
+			poeUsed := 0.0
 			for _, port := range usw.USW.PortTable {
-				portLabels := append(labelValues, port.Name, port.PortIdx.String(), port.Up.String(), port.IsUplink.String())
-				c.pRXPackets.WithLabelValues(portLabels...).Add(float64(port.RxPackets.Val))
-				c.pRXBytes.WithLabelValues(portLabels...).Add(float64(port.RxBytes.Val))
-				c.pRXErrors.WithLabelValues(portLabels...).Add(float64(port.RxErrors.Val))
-				c.pRXDropped.WithLabelValues(portLabels...).Add(float64(port.RxDropped.Val))
-				c.pSpeed.WithLabelValues(portLabels...).Set(float64(port.Speed.Val))
-				c.pTXPackets.WithLabelValues(portLabels...).Add(float64(port.TxPackets.Val))
-				c.pTXBytes.WithLabelValues(portLabels...).Add(float64(port.TxBytes.Val))
-				c.pTXErrors.WithLabelValues(portLabels...).Add(float64(port.TxErrors.Val))
-				c.pTXDropped.WithLabelValues(portLabels...).Add(float64(port.TxDropped.Val))
-				if port.SFPFound.Val {
-					c.pSFPTemp.WithLabelValues(portLabels...).Set(float64(port.SFPTemperature.Val))
+				portLabels := c.collectPort(counters, dev, port)
+				if port.PoeEnable.Val {
+					c.pPoEPower.WithLabelValues(append(portLabels, port.PoeMode)...).Set(port.PoePower.Val)
+					energy := c.poeEnergyKWh(usw.USW.Mac+"/"+port.PortIdx.String(), port.PoePower.Val)
+					counters.add(c.pPoEEnergy, energy, portLabels...)
+					poeUsed += port.PoePower.Val
 				}
 			}
+			// Switches without PoE report no budget
+			if usw.USW.TotalMaxPower.Val > 0 {
+				c.swPoEBudget.WithLabelValues(c.siteLabel(dev.Site()), dev.Name()).Set(usw.USW.TotalMaxPower.Val)
+				c.swPoEUsed.WithLabelValues(c.siteLabel(dev.Site()), dev.Name()).Set(poeUsed)
+			}
 		}
 		// Port metrics for UDM
 		if udm, ok := dev.(udmAdapter); ok {
 			for _, port := range udm.UDM.PortTable {
-				portLabels := append(labelValues, port.Name, port.PortIdx.String(), port.Up.String(), port.IsUplink.String())
-				c.pRXPackets.WithLabelValues(portLabels...).Add(float64(port.RxPackets.Val))
-				c.pRXBytes.WithLabelValues(portLabels...).Add(float64(port.RxBytes.Val))
-				c.pRXErrors.WithLabelValues(portLabels...).Add(float64(port.RxErrors.Val))
-				c.pRXDropped.WithLabelValues(portLabels...).Add(float64(port.RxDropped.Val))
-				c.pSpeed.WithLabelValues(portLabels...).Set(float64(port.Speed.Val))
-				c.pTXPackets.WithLabelValues(portLabels...).Add(float64(port.TxPackets.Val))
-				c.pTXBytes.WithLabelValues(portLabels...).Add(float64(port.TxBytes.Val))
-				c.pTXErrors.WithLabelValues(portLabels...).Add(float64(port.TxErrors.Val))
-				c.pTXDropped.WithLabelValues(portLabels...).Add(float64(port.TxDropped.Val))
-				if port.SFPFound.Val {
-					c.pSFPTemp.WithLabelValues(portLabels...).Set(float64(port.SFPTemperature.Val))
+				c.collectPort(counters, dev, port)
+			}
+			// Onboard and NVR drives, e.g. for Protect recordings
+			for _, st := range udm.UDM.Storage {
+				if st == nil {
+					continue
+				}
+				storageLabels := []string{c.siteLabel(dev.Site()), dev.Name(), st.Name, st.MountPoint}
+				c.udmStorageUsed.WithLabelValues(storageLabels...).Set(st.Used.Val)
+				c.udmStorageTotal.WithLabelValues(storageLabels...).Set(st.Size.Val)
+			}
+		}
+		// WAN metrics for gateways
+		switch gw := dev.(type) {
+		case udmAdapter:
+			c.collectWAN(counters, dev, gw.UDM.Uplink, wanISPs(gw.UDM.GeoInfo), gw.UDM.Wan1, gw.UDM.Wan2)
+			c.collectSpeedtest(dev, gw.UDM.SpeedtestStatus)
+		case usgAdapter:
+			// The USG reports no geo info, hence no ISP
+			c.collectWAN(counters, dev, gw.USG.Uplink, nil, gw.USG.Wan1, gw.USG.Wan2)
+			c.collectSpeedtest(dev, gw.USG.SpeedtestStatus)
+		}
+		// AP metrics for UAP
+		if uap, ok := dev.(uapAdapter); ok {
+			c.apClients.WithLabelValues(labelValues...).Set(uap.ClientCount())
+
+			widths := map[string]float64{}
+			for _, radio := range uap.UAP.RadioTable {
+				if radio.Ht.Val > 0 {
+					widths[radio.Radio] = radio.Ht.Val
+				}
+			}
+			steering := bandSteeringEnabled(uap.UAP.BandsteeringMode)
+			for _, vap := range uap.UAP.VapTable {
+				ssidLabels := []string{vap.Essid, uap.UAP.Mac, vap.Radio}
+				if width, ok := widths[vap.Radio]; ok {
+					c.ssidChannelWidth.WithLabelValues(ssidLabels...).Set(width)
+				}
+				if steering && vap.Radio == radio5GHz {
+					c.bandSteeringClients.WithLabelValues(ssidLabels...).Set(float64(vap.NumSta))
+				}
+				c.ssidClients.WithLabelValues(c.siteLabel(dev.Site()), dev.Name(), vap.Name, vap.Essid, vap.Radio).Set(float64(vap.NumSta))
+			}
+
+			for _, radio := range uap.UAP.RadioTableStats {
+				radioLabels := []string{c.siteLabel(dev.Site()), dev.Name(), radio.Radio, radio.Name}
+				c.radioChannel.WithLabelValues(radioLabels...).Set(radio.Channel.Val)
+				c.radioTxPower.WithLabelValues(radioLabels...).Set(radio.TxPower.Val)
+				c.radioUtilization.WithLabelValues(radioLabels...).Set(radio.CuTotal.Val)
+				c.radioClients.WithLabelValues(radioLabels...).Set(radio.NumSta.Val)
+				// Retries are counted against the packets sent, of which a
+				// radio without traffic has none
+				if radio.TxPackets.Val > 0 {
+					c.radioTxRetries.WithLabelValues(radioLabels...).Set(radio.TxRetries.Val / radio.TxPackets.Val * 100)
 				}
 			}
 		}
+
+		// Outlet metrics for PDU
+		if pdu, ok := dev.(pduAdapter); ok {
+			for _, outlet := range pdu.PDU.OutletTable {
+				outletLabels := []string{c.siteLabel(dev.Site()), dev.Name(), outlet.Name, outlet.Index.String()}
+				relay := 0.0
+				if outlet.RelayState.Val {
+					relay = 1
+				}
+				c.outletPower.WithLabelValues(outletLabels...).Set(outlet.OutletPower.Val)
+				c.outletCurrent.WithLabelValues(outletLabels...).Set(outlet.OutletCurrent.Val)
+				c.outletVoltage.WithLabelValues(outletLabels...).Set(outlet.OutletVoltage.Val)
+				c.outletRelay.WithLabelValues(outletLabels...).Set(relay)
+			}
+		}
+	}
+	for _, client := range c.cache.Clients {
+		clientLabels := []string{c.siteLabel(client.SiteName), client.Name, client.Mac, client.Network}
+		c.clientUptime.WithLabelValues(clientLabels...).Set(client.Uptime.Val)
+		// A zero timestamp means the controller has not seen the client
+		if client.LastSeen.Val > 0 {
+			c.clientLastSeen.WithLabelValues(clientLabels...).Set(client.LastSeen.Val)
+		}
+		if client.IsWired.Val {
+			continue
+		}
+		// A zero RSSI means none was reported
+		if client.Rssi.Val != 0 {
+			c.clientRssi.WithLabelValues(c.siteLabel(client.SiteName), client.Name, client.Mac, client.Network, client.ApMac, client.Essid).Set(client.Rssi.Val)
+		}
+		// The controller omits the score, or reports -1, until it has one
+		if client.Satisfaction.Txt != "" && client.Satisfaction.Val >= 0 {
+			c.clientSatisfaction.WithLabelValues(c.siteLabel(client.SiteName), client.Name, client.Mac, client.Network, client.ApMac).Set(client.Satisfaction.Val)
+		}
+	}
+	for _, client := range c.cache.Clients {
+		clientLabels := []string{c.siteLabel(client.SiteName), client.Name, client.Mac, client.Network}
+		ch <- prometheus.MustNewConstMetric(c.clientTXBytes, prometheus.CounterValue, client.TxBytes.Val, clientLabels...)
+		ch <- prometheus.MustNewConstMetric(c.clientRXBytes, prometheus.CounterValue, client.RxBytes.Val, clientLabels...)
+	}
+	// Alarms drop out of the list once archived, so the count is a gauge.
+	// The host is the gateway that raised the alarm.
+	for _, alarm := range c.cache.IDSAlarms {
+		c.idsAlarms.WithLabelValues(c.siteLabel(alarm.SiteName), alarm.Host, alarm.InnerAlertCategory).Inc()
+	}
+	counters.collect(ch)
+	c.prunePoEMeters()
+	c.collectDPI(ch)
+	c.collectSites()
+	for t, n := range c.cache.UnknownDevices {
+		c.unknownDevices.WithLabelValues(t).Set(float64(n))
 	}
 	c.deviceTemp.Collect(ch)
 	c.deviceCPU.Collect(ch)
 	c.deviceMem.Collect(ch)
-	c.swRXPackets.Collect(ch)
-	c.swRXBytes.Collect(ch)
-	c.swRXErrors.Collect(ch)
-	c.swRXDropped.Collect(ch)
-	c.swTXPackets.Collect(ch)
-	c.swTXBytes.Collect(ch)
-	c.swTXErrors.Collect(ch)
-	c.swTXDropped.Collect(ch)
-	c.swBytes.Collect(ch)
-	c.pRXPackets.Collect(ch)
-	c.pRXBytes.Collect(ch)
-	c.pRXErrors.Collect(ch)
-	c.pRXDropped.Collect(ch)
+	c.deviceLoad1.Collect(ch)
+	c.deviceLoad5.Collect(ch)
+	c.deviceLoad15.Collect(ch)
+	c.deviceProvisioning.Collect(ch)
+	c.deviceInfo.Collect(ch)
+	c.deviceUptime.Collect(ch)
+	c.deviceState.Collect(ch)
+	c.deviceAdopted.Collect(ch)
+	c.deviceClients.Collect(ch)
+	c.uplinkSpeed.Collect(ch)
+	c.uplinkUp.Collect(ch)
+	c.swPoEBudget.Collect(ch)
+	c.swPoEUsed.Collect(ch)
+	c.pUp.Collect(ch)
+	c.pSTPState.Collect(ch)
 	c.pSpeed.Collect(ch)
-	c.pTXPackets.Collect(ch)
-	c.pTXBytes.Collect(ch)
-	c.pTXErrors.Collect(ch)
-	c.pTXDropped.Collect(ch)
 	c.pSFPTemp.Collect(ch)
+	c.pSFPRx.Collect(ch)
+	c.pSFPTx.Collect(ch)
+	c.pSFPVolt.Collect(ch)
+	c.pPoEPower.Collect(ch)
+	c.wanRate.Collect(ch)
+	c.wanUptime.Collect(ch)
+	c.wanInfo.Collect(ch)
+	c.speedtestDown.Collect(ch)
+	c.speedtestUp.Collect(ch)
+	c.speedtestLatency.Collect(ch)
+	c.udmStorageUsed.Collect(ch)
+	c.udmStorageTotal.Collect(ch)
+	c.apClients.Collect(ch)
+	c.ssidChannelWidth.Collect(ch)
+	c.bandSteeringClients.Collect(ch)
+	c.ssidClients.Collect(ch)
+	c.radioChannel.Collect(ch)
+	c.radioTxPower.Collect(ch)
+	c.radioUtilization.Collect(ch)
+	c.radioClients.Collect(ch)
+	c.radioTxRetries.Collect(ch)
+	c.outletPower.Collect(ch)
+	c.outletCurrent.Collect(ch)
+	c.outletVoltage.Collect(ch)
+	c.outletRelay.Collect(ch)
+	c.clientRssi.Collect(ch)
+	c.clientSatisfaction.Collect(ch)
+	c.clientUptime.Collect(ch)
+	c.clientLastSeen.Collect(ch)
+	c.siteDevices.Collect(ch)
+	c.siteClients.Collect(ch)
+	c.siteWANStatus.Collect(ch)
+	c.siteDisconnected.Collect(ch)
+	c.idsAlarms.Collect(ch)
+	c.unknownDevices.Collect(ch)
+	c.precisionLoss.Collect(ch)
+	c.up.Collect(ch)
+	c.scrapeErrors.Collect(ch)
+	c.duration.Collect(ch)
+	c.lastScrape.Collect(ch)
+}
+
+// counterKey identifies a const counter series by its label values, joined
+// with a separator that cannot occur in them.
+type counterKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+// counterSet collects the counters of a scrape. Series with the same labels,
+// e.g. of two unnamed devices, are summed, as const metrics must be unique.
+type counterSet map[counterKey]float64
+
+func (s counterSet) add(desc *prometheus.Desc, v float64, labelValues ...string) {
+	s[counterKey{desc, strings.Join(labelValues, "\xff")}] += v
+}
+
+func (s counterSet) collect(ch chan<- prometheus.Metric) {
+	for key, v := range s {
+		ch <- prometheus.MustNewConstMetric(key.desc, prometheus.CounterValue, v, strings.Split(key.labels, "\xff")...)
+	}
+}
+
+// dpiKey identifies a DPI series. Clients without a name are labelled with
+// their MAC.
+type dpiKey struct {
+	site, app, category, client string
+}
+
+// collectDPI emits the DPI byte counters. Rows that end up with the same
+// labels, e.g. two clients sharing a name, are summed, as const metrics must
+// be unique.
+func (c *UniFiCollector) collectDPI(ch chan<- prometheus.Metric) {
+	if len(c.cache.DPI) == 0 {
+		return
+	}
+	names := map[string]string{}
+	for _, client := range c.cache.Clients {
+		if client.Name != "" {
+			names[client.Mac] = client.Name
+		} else if client.Hostname != "" {
+			names[client.Mac] = client.Hostname
+		}
+	}
+	tx := map[dpiKey]float64{}
+	rx := map[dpiKey]float64{}
+	for _, table := range c.cache.DPI {
+		client, ok := names[table.MAC]
+		if !ok {
+			client = table.MAC
+		}
+		for _, d := range table.ByApp {
+			key := dpiKey{
+				site:     c.siteLabel(table.SiteName),
+				app:      unifi.DPIApps.GetApp(d.Cat.Int(), d.App.Int()),
+				category: unifi.DPICats.Get(d.Cat.Int()),
+				client:   client,
+			}
+			tx[key] += d.TxBytes.Val
+			rx[key] += d.RxBytes.Val
+		}
+	}
+	for key, v := range tx {
+		ch <- prometheus.MustNewConstMetric(c.dpiTXBytes, prometheus.CounterValue, v, key.site, key.app, key.category, key.client)
+	}
+	for key, v := range rx {
+		ch <- prometheus.MustNewConstMetric(c.dpiRXBytes, prometheus.CounterValue, v, key.site, key.app, key.category, key.client)
+	}
+}
+
+// collectWAN sets the WAN metrics of a gateway, labelling the interfaces
+// wan1, wan2 in order. Ports without an interface name are not configured
+// as WAN and are skipped. uplink is the active WAN, the only one with an
+// uptime, and isps holds the ISP of each WAN where known.
+func (c *UniFiCollector) collectWAN(counters counterSet, dev UnifiDevice, uplink unifi.Uplink, isps []string, wans ...unifi.Wan) {
+	for i, wan := range wans {
+		if wan.Ifname == "" {
+			continue
+		}
+		wanName := fmt.Sprintf("wan%d", i+1)
+		wanLabels := []string{c.siteLabel(dev.Site()), dev.Name(), wanName, wan.IP}
+		counters.add(c.wanRXBytes, wan.RxBytes.Val, wanLabels...)
+		counters.add(c.wanTXBytes, wan.TxBytes.Val, wanLabels...)
+		c.wanRate.WithLabelValues(wanLabels...).Set(wan.BytesR.Val)
+		if uplink.Name == wan.Ifname {
+			c.wanUptime.WithLabelValues(c.siteLabel(dev.Site()), dev.Name(), wanName).Set(uplink.Uptime.Val)
+		}
+		isp := ""
+		if i < len(isps) {
+			isp = isps[i]
+		}
+		c.wanInfo.WithLabelValues(c.siteLabel(dev.Site()), dev.Name(), wanName, isp, wan.IP).Set(1)
+	}
+}
+
+// wanISPs returns the ISPs of wan1 and wan2 from the geo info of a gateway,
+// which keys them WAN and WAN2.
+func wanISPs(geo map[string]unifi.GeoInfo) []string {
+	return []string{geo["WAN"].IspName, geo["WAN2"].IspName}
+}
+
+// collectSpeedtest sets the results of the latest speed test of a gateway.
+// The controller reports throughput in Mbps. Gateways that never ran a test
+// are skipped.
+func (c *UniFiCollector) collectSpeedtest(dev UnifiDevice, st unifi.SpeedtestStatus) {
+	if st.Rundate.Val == 0 {
+		return
+	}
+	c.speedtestDown.WithLabelValues(c.siteLabel(dev.Site()), dev.Name()).Set(st.XputDownload.Val * 1e6)
+	c.speedtestUp.WithLabelValues(c.siteLabel(dev.Site()), dev.Name()).Set(st.XputUpload.Val * 1e6)
+	c.speedtestLatency.WithLabelValues(c.siteLabel(dev.Site()), dev.Name()).Set(st.Latency.Val)
+}
+
+// siteLabel returns the value of the site label for the named site: the
+// name itself, or the ID of the site if UniFiSiteIDLabels is set.
+func (c *UniFiCollector) siteLabel(siteName string) string {
+	if id, ok := c.siteIDs[siteName]; ok {
+		return id
+	}
+	return siteName
+}
+
+// collectSites sets the per-site summary metrics. Devices and clients are
+// matched to their site by the site name the controller client gives them.
+func (c *UniFiCollector) collectSites() {
+	devices := map[string]int{}
+	for _, dev := range c.cache.Devices.All() {
+		devices[dev.Site()]++
+	}
+	clients := map[string]int{}
+	for _, client := range c.cache.Clients {
+		clients[client.SiteName]++
+	}
+
+	for _, s := range c.cache.Sites {
+		// The same label as the device and client metrics, so that they join
+		siteLabels := []string{c.siteLabel(s.SiteName), s.Desc}
+		c.siteDevices.WithLabelValues(siteLabels...).Set(float64(devices[s.SiteName]))
+		c.siteClients.WithLabelValues(siteLabels...).Set(float64(clients[s.SiteName]))
+		disconnected := 0.0
+		for _, h := range s.Health {
+			disconnected += h.NumDisconnected.Val
+			if h.Subsystem == "wan" {
+				wanOK := 0.0
+				if h.Status == "ok" {
+					wanOK = 1
+				}
+				c.siteWANStatus.WithLabelValues(siteLabels...).Set(wanOK)
+			}
+		}
+		c.siteDisconnected.WithLabelValues(siteLabels...).Set(disconnected)
+	}
+}
+
+// PowerWatts returns the PoE power drawn from all switches, as last read
+// from their PoE enabled ports.
+func (c *UniFiCollector) PowerWatts() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.runner.stale() {
+		return 0
+	}
+	total := 0.0
+	for _, usw := range c.cache.Devices.USWs {
+		for _, port := range usw.PortTable {
+			if port.PoeEnable.Val {
+				total += port.PoePower.Val
+			}
+		}
+	}
+	return total
+}
+
+// poeMeter accumulates the energy drawn by a PoE port.
+type poeMeter struct {
+	kwh  float64
+	last time.Time
+	// seen is set once the port is collected in the current scrape
+	seen bool
+}
+
+// collectPort sets the metrics that switch and UDM ports have in common and
+// returns the port label values for the metrics that are specific to
+// switches.
+func (c *UniFiCollector) collectPort(counters counterSet, dev UnifiDevice, port unifi.Port) []string {
+	portLabels := []string{dev.Type(), c.siteLabel(dev.Site()), dev.IP(), dev.Name(), port.Name, port.PortIdx.String(), port.IsUplink.String()}
+	if c.minimalPortLabels {
+		portLabels = []string{dev.Type(), c.siteLabel(dev.Site()), dev.Name(), port.Name, port.PortIdx.String()}
+	}
+	up := 0.0
+	if port.Up.Val {
+		up = 1
+	}
+	c.pUp.WithLabelValues(portLabels...).Set(up)
+	if state, ok := stpStateValue(port.StpState); ok {
+		c.pSTPState.WithLabelValues(portLabels...).Set(state)
+	}
+	counters.add(c.pRXPackets, port.RxPackets.Val, portLabels...)
+	counters.add(c.pRXBytes, port.RxBytes.Val, portLabels...)
+	counters.add(c.pRXErrors, port.RxErrors.Val, portLabels...)
+	counters.add(c.pRXDropped, port.RxDropped.Val, portLabels...)
+	c.pSpeed.WithLabelValues(portLabels...).Set(float64(port.Speed.Val))
+	counters.add(c.pTXPackets, port.TxPackets.Val, portLabels...)
+	counters.add(c.pTXBytes, port.TxBytes.Val, portLabels...)
+	counters.add(c.pTXErrors, port.TxErrors.Val, portLabels...)
+	counters.add(c.pTXDropped, port.TxDropped.Val, portLabels...)
+	if port.SFPFound.Val {
+		c.pSFPTemp.WithLabelValues(portLabels...).Set(float64(port.SFPTemperature.Val))
+		c.pSFPRx.WithLabelValues(portLabels...).Set(port.SFPRxpower.Val)
+		c.pSFPTx.WithLabelValues(portLabels...).Set(port.SFPTxpower.Val)
+		c.pSFPVolt.WithLabelValues(portLabels...).Set(port.SFPVoltage.Val)
+	}
+	return portLabels
+}
+
+// connectedClients counts the clients per MAC of the device they connect
+// through: the AP for wireless clients and the switch for wired ones.
+func connectedClients(clients []unifi.Client) map[string]int {
+	counts := map[string]int{}
+	for _, client := range clients {
+		mac := client.ApMac
+		if client.IsWired.Val {
+			mac = client.SwMac
+		}
+		if mac != "" {
+			counts[strings.ToLower(mac)]++
+		}
+	}
+	return counts
+}
+
+// deviceKey identifies a device by the labels of unifi_device_state.
+type deviceKey struct {
+	typ, site, name string
+}
+
+// collectMissingDevices remembers the devices in the cache and reports the
+// ones that are no longer listed as disconnected. Must be called with the
+// mutex held.
+func (c *UniFiCollector) collectMissingDevices() {
+	listed := map[deviceKey]bool{}
+	for _, dev := range c.cache.Devices.All() {
+		key := deviceKey{typ: dev.Type(), site: dev.Site(), name: dev.Name()}
+		listed[key] = true
+		c.knownDevices[key] = true
+	}
+	for key := range c.knownDevices {
+		if !listed[key] {
+			c.deviceState.WithLabelValues(key.typ, c.siteLabel(key.site), key.name).Set(0)
+		}
+	}
+}
+
+// stpStateValue maps the spanning tree state of a port to the values of
+// dot1dStpPortState in the BRIDGE-MIB. ok is false for an unknown or missing
+// state, e.g. on ports of devices that do not run STP.
+func stpStateValue(state string) (value float64, ok bool) {
+	switch strings.ToLower(state) {
+	case "disabled":
+		return 1, true
+	case "blocking", "discarding":
+		return 2, true
+	case "listening":
+		return 3, true
+	case "learning":
+		return 4, true
+	case "forwarding":
+		return 5, true
+	case "broken":
+		return 6, true
+	}
+	return 0, false
+}
+
+// poeEnergyKWh integrates the given PoE power (W) over the time since the
+// previous scrape and returns the port's energy total. Switches only report
+// instantaneous power, so the total starts at zero when the exporter starts
+// and its accuracy depends on the scrape interval. Must be called with the
+// mutex held.
+func (c *UniFiCollector) poeEnergyKWh(key string, watts float64) float64 {
+	now := c.now()
+	m, ok := c.poeEnergy[key]
+	if !ok {
+		m = &poeMeter{}
+		c.poeEnergy[key] = m
+	} else if elapsed := now.Sub(m.last); elapsed > 0 {
+		m.kwh += watts * elapsed.Hours() / 1000
+	}
+	m.last = now
+	m.seen = true
+	return m.kwh
+}
+
+// prunePoEMeters drops the meters of ports that were not collected in this
+// scrape. A port whose PoE was off for a while thus starts over once it is
+// back, rather than integrating the gap at its new power. Must be called
+// with the mutex held.
+func (c *UniFiCollector) prunePoEMeters() {
+	for key, m := range c.poeEnergy {
+		if !m.seen {
+			delete(c.poeEnergy, key)
+		}
+		m.seen = false
+	}
+}
+
+// radio5GHz is the controller's radio name for the 5GHz band.
+const radio5GHz = "na"
+
+// bandSteeringEnabled reports whether the AP steers dual-band clients to
+// 5GHz. Controllers omit the mode on APs without band steering support.
+func bandSteeringEnabled(mode string) bool {
+	return mode != "" && mode != "off"
+}
+
+// maxExactFloat is the largest integer float64 can represent without gaps.
+const maxExactFloat = 1 << 53
+
+// countPrecisionLoss adds the counters among values that cannot be exported
+// exactly as float64 to precisionLoss. fetch calls it once for every part
+// of the cache it replaces, so each value fetched is counted once.
+func (c *UniFiCollector) countPrecisionLoss(values []unifi.FlexInt) {
+	for _, f := range values {
+		if lostPrecision(f) {
+			c.precisionLoss.Inc()
+			Debugf("UniFi: counter value %s exceeds float64 precision", f.Txt)
+		}
+	}
+}
+
+// deviceCounters returns the counters of devices that Collect exports.
+func deviceCounters(devices UnifiDevices) []unifi.FlexInt {
+	var values []unifi.FlexInt
+	ports := func(table []unifi.Port) {
+		for _, p := range table {
+			values = append(values, p.RxPackets, p.RxBytes, p.RxErrors, p.RxDropped, p.TxPackets, p.TxBytes, p.TxErrors, p.TxDropped)
+		}
+	}
+	wans := func(wans ...unifi.Wan) {
+		for _, w := range wans {
+			if w.Ifname != "" {
+				values = append(values, w.RxBytes, w.TxBytes)
+			}
+		}
+	}
+	for _, d := range devices.USWs {
+		if st := d.Stat.Sw; st != nil {
+			values = append(values, st.RxPackets, st.RxBytes, st.RxErrors, st.RxDropped, st.TxPackets, st.TxBytes, st.TxErrors, st.TxDropped, st.Bytes)
+		}
+		ports(d.PortTable)
+	}
+	for _, d := range devices.UDMs {
+		ports(d.PortTable)
+		wans(d.Wan1, d.Wan2)
+	}
+	for _, d := range devices.USGs {
+		wans(d.Wan1, d.Wan2)
+	}
+	return values
+}
+
+// clientCounters returns the traffic counters of clients and their DPI
+// applications.
+func clientCounters(clients []unifi.Client, dpi []unifi.DPITable) []unifi.FlexInt {
+	var values []unifi.FlexInt
+	for _, client := range clients {
+		values = append(values, client.TxBytes, client.RxBytes)
+	}
+	for _, table := range dpi {
+		for _, d := range table.ByApp {
+			values = append(values, d.TxBytes, d.RxBytes)
+		}
+	}
+	return values
+}
+
+// lostPrecision reports whether f.Val no longer matches the integer the
+// controller sent.
+func lostPrecision(f unifi.FlexInt) bool {
+	if math.Abs(f.Val) < maxExactFloat {
+		return false
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(f.Txt), 10, 64)
+	if err != nil {
+		// Not a plain integer, so exactness can't be verified.
+		return true
+	}
+	return f.Val >= math.MaxUint64 || uint64(f.Val) != n
 }
 
 func resetAll(c *UniFiCollector) {
 	c.deviceTemp.Reset()
 	c.deviceCPU.Reset()
 	c.deviceMem.Reset()
-	c.swRXPackets.Reset()
-	c.swRXBytes.Reset()
-	c.swRXErrors.Reset()
-	c.swRXDropped.Reset()
-	c.swTXPackets.Reset()
-	c.swTXBytes.Reset()
-	c.swTXErrors.Reset()
-	c.swTXDropped.Reset()
-	c.swBytes.Reset()
-	c.pRXPackets.Reset()
-	c.pRXBytes.Reset()
-	c.pRXErrors.Reset()
-	c.pRXDropped.Reset()
+	c.deviceLoad1.Reset()
+	c.deviceLoad5.Reset()
+	c.deviceLoad15.Reset()
+	c.deviceProvisioning.Reset()
+	c.deviceInfo.Reset()
+	c.deviceUptime.Reset()
+	c.deviceState.Reset()
+	c.deviceAdopted.Reset()
+	c.deviceClients.Reset()
+	c.uplinkSpeed.Reset()
+	c.uplinkUp.Reset()
+	c.swPoEBudget.Reset()
+	c.swPoEUsed.Reset()
+	c.pUp.Reset()
+	c.pSTPState.Reset()
 	c.pSpeed.Reset()
-	c.pTXPackets.Reset()
-	c.pTXBytes.Reset()
-	c.pTXErrors.Reset()
-	c.pTXDropped.Reset()
 	c.pSFPTemp.Reset()
+	c.pSFPRx.Reset()
+	c.pSFPTx.Reset()
+	c.pSFPVolt.Reset()
+	c.pPoEPower.Reset()
+	c.wanRate.Reset()
+	c.wanUptime.Reset()
+	c.wanInfo.Reset()
+	c.speedtestDown.Reset()
+	c.speedtestUp.Reset()
+	c.speedtestLatency.Reset()
+	c.udmStorageUsed.Reset()
+	c.udmStorageTotal.Reset()
+	c.apClients.Reset()
+	c.ssidChannelWidth.Reset()
+	c.bandSteeringClients.Reset()
+	c.ssidClients.Reset()
+	c.radioChannel.Reset()
+	c.radioTxPower.Reset()
+	c.radioUtilization.Reset()
+	c.radioClients.Reset()
+	c.radioTxRetries.Reset()
+	c.outletPower.Reset()
+	c.outletCurrent.Reset()
+	c.outletVoltage.Reset()
+	c.outletRelay.Reset()
+	c.clientRssi.Reset()
+	c.clientSatisfaction.Reset()
+	c.clientUptime.Reset()
+	c.clientLastSeen.Reset()
+	c.siteDevices.Reset()
+	c.siteClients.Reset()
+	c.siteWANStatus.Reset()
+	c.siteDisconnected.Reset()
+	c.idsAlarms.Reset()
+	c.unknownDevices.Reset()
 }
 
-func (c *UniFiCollector) run() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// Ready reports whether a fetch has succeeded at least once.
+func (c *UniFiCollector) Ready() bool {
+	return c.runner.Ready()
+}
 
-	for {
-		if err := c.fetch(); err != nil {
-			log.Println("Error fetching UniFi data:", err)
-		}
-		<-ticker.C
-	}
+// Health summarizes the fetches so far.
+func (c *UniFiCollector) Health() Health {
+	return c.runner.Health()
+}
+
+// Stop ends the background fetch loop. It must be called at most once.
+func (c *UniFiCollector) Stop() {
+	c.runner.Stop()
 }
 
 // fetchData fetches data from the UniFi controller
-func (c *UniFiCollector) fetch() error {
+func (c *UniFiCollector) fetch(ctx context.Context) error {
 	if _, err := c.client.GetSites(); err != nil {
-		if err := c.client.Login(); err != nil {
-			log.Println("UniFi login error:", err)
-			return err
+		if err := c.login(ctx); err != nil {
+			c.fetchFailed()
+			return fmt.Errorf("logging in: %w", err)
 		}
 	}
 
-	sites, _ := c.client.GetSites()
-	clients, _ := c.client.GetClients(sites)
-	devices, _ := c.client.GetDevices(sites)
+	// Without sites nothing else can be queried, so keep serving the
+	// previous cache
+	sites, err := c.client.GetSites()
+	if err != nil {
+		c.fetchFailed()
+		return fmt.Errorf("getting sites: %w", err)
+	}
+	// Only the allowed sites are queried, which also limits the devices
+	// and clients returned
+	sites = filterSites(sites, c.sites)
 
-	var siteVals []unifi.Site
+	// The other calls fail on their own: each failure keeps the previous
+	// cache of that call only, and the errors are returned together
+	var errs []error
+	var data UnifiData
 	for _, s := range sites {
 		if s != nil {
-			siteVals = append(siteVals, *s)
+			data.Sites = append(data.Sites, *s)
 		}
 	}
-	var clientVals []unifi.Client
+	clients, err := c.client.GetClients(sites)
+	clientsOK := err == nil
+	if err != nil {
+		c.fetchFailed()
+		errs = append(errs, fmt.Errorf("getting clients: %w", err))
+	}
 	for _, cp := range clients {
 		if cp != nil {
-			clientVals = append(clientVals, *cp)
+			data.Clients = append(data.Clients, *cp)
 		}
 	}
-	var udms []unifi.UDM
-	var usgs []unifi.USG
-	var usw []unifi.USW
-	var uaps []unifi.UAP
+	devices, err := c.client.GetDevices(sites)
+	// Guard the device loops against a response without devices, which
+	// the client returns along with most errors
+	if err == nil && devices == nil {
+		err = errors.New("controller returned no devices")
+	}
+	devicesOK := err == nil
+	if err != nil {
+		c.fetchFailed()
+		errs = append(errs, fmt.Errorf("getting devices: %w", err))
+	} else {
+		data.Devices = unifiDevices(devices)
+		data.UnknownDevices = countUnknownDevices(devices)
+	}
+	dpiOK, idsOK := true, true
+	if c.dpi {
+		tables, err := c.client.GetClientsDPI(sites)
+		if err != nil {
+			dpiOK = false
+			c.fetchFailed()
+			errs = append(errs, fmt.Errorf("getting DPI statistics: %w", err))
+		}
+		for _, t := range tables {
+			if t != nil {
+				data.DPI = append(data.DPI, *t)
+			}
+		}
+	}
+	if c.ids {
+		alarms, err := c.client.GetAlarms(sites)
+		if err != nil {
+			idsOK = false
+			c.fetchFailed()
+			errs = append(errs, fmt.Errorf("getting alarms: %w", err))
+		}
+		// Other alarms, e.g. of disconnected devices, have no category
+		for _, a := range alarms {
+			if a != nil && a.InnerAlertCategory != "" {
+				data.IDSAlarms = append(data.IDSAlarms, *a)
+			}
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cache.Sites = data.Sites
+	if clientsOK {
+		c.cache.Clients = data.Clients
+		c.countPrecisionLoss(clientCounters(data.Clients, nil))
+	}
+	if devicesOK {
+		c.cache.Devices = data.Devices
+		c.cache.UnknownDevices = data.UnknownDevices
+		c.countPrecisionLoss(deviceCounters(data.Devices))
+	}
+	if dpiOK {
+		c.cache.DPI = data.DPI
+		c.countPrecisionLoss(clientCounters(nil, data.DPI))
+	}
+	if idsOK {
+		c.cache.IDSAlarms = data.IDSAlarms
+	}
+	return errors.Join(errs...)
+}
+
+// unifiDevices copies the devices that have an adapter out of the controller
+// response.
+func unifiDevices(devices *unifi.Devices) UnifiDevices {
+	var all UnifiDevices
 	for _, d := range devices.UDMs {
 		if d == nil {
 			continue
 		}
-		udms = append(udms, *d)
+		all.UDMs = append(all.UDMs, *d)
 	}
 	for _, d := range devices.USGs {
 		if d == nil {
 			continue
 		}
-		usgs = append(usgs, *d)
+		all.USGs = append(all.USGs, *d)
 	}
 	for _, d := range devices.USWs {
 		if d == nil {
 			continue
 		}
-		usw = append(usw, *d)
+		all.USWs = append(all.USWs, *d)
 	}
 	for _, d := range devices.UAPs {
 		if d == nil {
 			continue
 		}
-		uaps = append(uaps, *d)
+		all.UAPs = append(all.UAPs, *d)
 	}
-	// create the cache
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	// Reset the cache before updating
-	c.cache = UnifiData{
-		Sites: siteVals,
-		Devices: UnifiDevices{
-			UDMs: udms,
-			USGs: usgs,
-			USWs: usw,
-			UAPs: uaps,
-		},
-		Clients: clientVals,
-	}
-	return nil
+	for _, d := range devices.PDUs {
+		if d == nil {
+			continue
+		}
+		all.PDUs = append(all.PDUs, *d)
+	}
+	return all
+}
+
+// siteSet returns the allowlist of the given sites, or nil if there are none.
+func siteSet(sites []string) map[string]bool {
+	if len(sites) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(sites))
+	for _, s := range sites {
+		set[s] = true
+	}
+	return set
+}
+
+// filterSites returns the sites whose name or description is in allow. A nil
+// allowlist keeps every site.
+func filterSites(sites []*unifi.Site, allow map[string]bool) []*unifi.Site {
+	if allow == nil {
+		return sites
+	}
+	var filtered []*unifi.Site
+	for _, s := range sites {
+		if s != nil && (allow[s.Name] || allow[s.Desc]) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// login logs in to the controller, retrying with exponential backoff so that
+// a controller restarting, e.g. during its nightly backup, does not fail the
+// scrape.
+func (c *UniFiCollector) login(ctx context.Context) error {
+	backoff := c.loginBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.client.Login()
+		if err == nil || attempt >= c.loginAttempts {
+			return err
+		}
+		log.Printf("UniFi login attempt %d failed, retrying in %v: %v", attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// fetchFailed records a failed controller call.
+func (c *UniFiCollector) fetchFailed() {
+	c.scrapeErrors.Inc()
+}
+
+// countUnknownDevices counts the devices in the raw controller response that
+// have no adapter and would otherwise be dropped silently.
+func countUnknownDevices(devices *unifi.Devices) map[string]int {
+	unknown := map[string]int{}
+	count := func(kind string, n int) {
+		if n > 0 {
+			unknown[kind] = n
+			Debugf("UniFi: skipping %d device(s) of unhandled type %s", n, kind)
+		}
+	}
+	count("UXG", countNonNil(devices.UXGs))
+	count("UBB", countNonNil(devices.UBBs))
+	count("UCI", countNonNil(devices.UCIs))
+	return unknown
+}
+
+func countNonNil[T any](devs []*T) int {
+	n := 0
+	for _, d := range devs {
+		if d != nil {
+			n++
+		}
+	}
+	return n
 }
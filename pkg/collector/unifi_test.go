@@ -1,6 +1,11 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +22,10 @@ type mockClient struct {
 	Clients  []*unifi.Client
 	Devices  *unifi.Devices
 	Err      error
+	// DevicesErr fails GetDevices only
+	DevicesErr error
+	DPI        []*unifi.DPITable
+	Alarms     []*unifi.Alarm
 }
 
 func (m *mockClient) Login() error {
@@ -36,9 +45,20 @@ func (m *mockClient) GetClients(_ []*unifi.Site) ([]*unifi.Client, error) {
 }
 
 func (m *mockClient) GetDevices(_ []*unifi.Site) (*unifi.Devices, error) {
+	if m.DevicesErr != nil {
+		return nil, m.DevicesErr
+	}
 	return m.Devices, nil
 }
 
+func (m *mockClient) GetClientsDPI(_ []*unifi.Site) ([]*unifi.DPITable, error) {
+	return m.DPI, nil
+}
+
+func (m *mockClient) GetAlarms(_ []*unifi.Site) ([]*unifi.Alarm, error) {
+	return m.Alarms, nil
+}
+
 func TestCollectorCollect(t *testing.T) {
 	mc := &mockClient{
 		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
@@ -82,9 +102,9 @@ func TestCollectorCollect(t *testing.T) {
 		},
 	}
 
-	col := NewUniFiCollectorWithClient(mc)
+	col := newUniFiCollectorWithClient(mc)
 
-	err := col.fetch()
+	err := col.fetch(context.Background())
 	assert.NoError(t, err)
 
 	registry := prometheus.NewRegistry()
@@ -98,11 +118,1007 @@ func TestCollectorCollect(t *testing.T) {
 	t.Logf("Collected %d metrics", count)
 	assert.Greater(t, count, 0)
 
-	// Check device temperature
-	tempVal := testutil.ToFloat64(col.deviceTemp.WithLabelValues("uap-1", "192.168.1.2"))
-	assert.Equal(t, 0.0, tempVal) // Assuming no temperature data is set in mock
-	cpuVal := testutil.ToFloat64(col.deviceCPU.WithLabelValues("uap-1", "192.168.1.2"))
+	// UAPs report no temperature
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_device_temperature_celsius"))
+	cpuVal := testutil.ToFloat64(col.deviceCPU.WithLabelValues("UAP", "", "192.168.1.2", "uap-1", ""))
 	assert.Equal(t, 10.0, cpuVal)
-	memVal := testutil.ToFloat64(col.deviceMem.WithLabelValues("uap-1", "192.168.1.2"))
+	memVal := testutil.ToFloat64(col.deviceMem.WithLabelValues("UAP", "", "192.168.1.2", "uap-1", ""))
 	assert.Equal(t, 20.0, memVal)
+	clientsVal := testutil.ToFloat64(col.apClients.WithLabelValues("UAP", "", "192.168.1.2", "uap-1"))
+	assert.Equal(t, 3.0, clientsVal)
+}
+
+func TestCollectorUnknownDevices(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UAPs: []*unifi.UAP{{Name: "uap-1"}},
+			UXGs: []*unifi.UXG{{Name: "uxg-1"}, {Name: "uxg-2"}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	testutil.CollectAndCount(col)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.unknownDevices.WithLabelValues("UXG")))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_unknown_devices"))
+}
+
+func TestCollectorPDU(t *testing.T) {
+	pdu := &unifi.PDU{Name: "usp-pdu", SiteName: "default", OutletTable: []unifi.OutletTable{
+		{Index: *unifi.NewFlexInt(1), Name: "NAS", OutletPower: *unifi.NewFlexInt(42.5), OutletCurrent: *unifi.NewFlexInt(0.19), OutletVoltage: *unifi.NewFlexInt(230), RelayState: unifi.FlexBool{Val: true, Txt: "true"}},
+		{Index: *unifi.NewFlexInt(2), Name: "Spare", OutletVoltage: *unifi.NewFlexInt(230)},
+	}}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{PDUs: []*unifi.PDU{pdu}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_outlet_power_watts PDU outlet power draw (W)
+# TYPE unifi_outlet_power_watts gauge
+unifi_outlet_power_watts{name="usp-pdu",outlet="NAS",outlet_index="1",site="default"} 42.5
+unifi_outlet_power_watts{name="usp-pdu",outlet="Spare",outlet_index="2",site="default"} 0
+# HELP unifi_outlet_relay_state Whether the PDU outlet relay is on (1) or off (0)
+# TYPE unifi_outlet_relay_state gauge
+unifi_outlet_relay_state{name="usp-pdu",outlet="NAS",outlet_index="1",site="default"} 1
+unifi_outlet_relay_state{name="usp-pdu",outlet="Spare",outlet_index="2",site="default"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_outlet_power_watts", "unifi_outlet_relay_state"))
+	assert.Equal(t, 0.19, testutil.ToFloat64(col.outletCurrent.WithLabelValues("default", "usp-pdu", "NAS", "1")))
+	assert.Equal(t, 230.0, testutil.ToFloat64(col.outletVoltage.WithLabelValues("default", "usp-pdu", "NAS", "1")))
+	// The PDU is no longer counted as unhandled
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_unknown_devices"))
+}
+
+func TestCollectorSiteIDLabels(t *testing.T) {
+	UniFiSiteIDLabels = true
+	defer func() { UniFiSiteIDLabels = false }()
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", SiteName: "Home (default)", Desc: "Home", ID: "5f1a2b"}},
+		Devices: &unifi.Devices{
+			USWs: []*unifi.USW{{Name: "usw-1", SiteName: "Home (default)", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}},
+		},
+		Clients: []*unifi.Client{{SiteName: "Home (default)", Name: "nas", Mac: "22:22", IsWired: unifi.FlexBool{Val: true, Txt: "true"}}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_device_state Whether the device is connected to the controller (1) or not (0)
+# TYPE unifi_device_state gauge
+unifi_device_state{name="usw-1",site="5f1a2b",type="USW"} 0
+# HELP unifi_site_devices Devices of the site
+# TYPE unifi_site_devices gauge
+unifi_site_devices{desc="Home",site="5f1a2b"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_state", "unifi_site_devices"))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_client_uptime_seconds"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.clientUptime.WithLabelValues("5f1a2b", "nas", "22:22", "")))
+}
+
+func TestLostPrecision(t *testing.T) {
+	assert.False(t, lostPrecision(*unifi.NewFlexInt(1000)))
+	assert.False(t, lostPrecision(unifi.FlexInt{Val: 1 << 53, Txt: "9007199254740992"}))
+	assert.True(t, lostPrecision(unifi.FlexInt{Val: 9007199254740993, Txt: "9007199254740993"}))
+}
+
+func TestCollectorPrecisionLoss(t *testing.T) {
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{},
+		Clients: []*unifi.Client{{SiteName: "default", Name: "nas", Mac: "22:22", TxBytes: unifi.FlexInt{Val: 9007199254740993, Txt: "9007199254740993"}}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	// Scrapes export the cached value without counting it again
+	testutil.CollectAndCount(col)
+	testutil.CollectAndCount(col)
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.precisionLoss))
+}
+
+func TestCollectorDeviceProvisioning(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			USWs: []*unifi.USW{
+				{Name: "usw-1", IP: "192.168.1.3", State: *unifi.NewFlexInt(stateProvisioning), Stat: unifi.USWStat{Sw: &unifi.Sw{}}},
+				{Name: "usw-2", IP: "192.168.1.4", State: *unifi.NewFlexInt(stateConnected), Stat: unifi.USWStat{Sw: &unifi.Sw{}}},
+			},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	testutil.CollectAndCount(col)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.deviceProvisioning.WithLabelValues("USW", "", "192.168.1.3", "usw-1")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceProvisioning.WithLabelValues("USW", "", "192.168.1.4", "usw-2")))
+}
+
+func TestCollectorDeviceState(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UAPs: []*unifi.UAP{
+				{Name: "uap-1", SiteName: "default", State: *unifi.NewFlexInt(stateConnected), Adopted: unifi.FlexBool{Val: true}},
+				{Name: "uap-2", SiteName: "default", State: *unifi.NewFlexInt(stateDisconnected), Adopted: unifi.FlexBool{Val: true}},
+			},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 4, testutil.CollectAndCount(col, "unifi_device_state", "unifi_device_adopted"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.deviceState.WithLabelValues("UAP", "default", "uap-1")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceState.WithLabelValues("UAP", "default", "uap-2")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.deviceAdopted.WithLabelValues("UAP", "default", "uap-2")))
+}
+
+func TestCollectorOfflineDevices(t *testing.T) {
+	// Offline devices come back with little more than a name: no system
+	// stats, and no stat block for switches
+	offline := *unifi.NewFlexInt(stateDisconnected)
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{{Name: "udm-1", SiteName: "default", IP: "192.168.1.1", State: offline}},
+			USGs: []*unifi.USG{{Name: "usg-1", SiteName: "default", IP: "192.168.1.2", State: offline}},
+			USWs: []*unifi.USW{{Name: "usw-1", SiteName: "default", IP: "192.168.1.3", State: offline}},
+			UAPs: []*unifi.UAP{{Name: "uap-1", SiteName: "default", IP: "192.168.1.4", State: offline}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.NotPanics(t, func() { testutil.CollectAndCount(col) })
+	assert.Equal(t, 4, testutil.CollectAndCount(col, "unifi_device_cpu_pct"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceCPU.WithLabelValues("USW", "default", "192.168.1.3", "usw-1", "")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceMem.WithLabelValues("USW", "default", "192.168.1.3", "usw-1", "")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceState.WithLabelValues("USW", "default", "usw-1")))
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_switch_rx_bytes_total"))
+}
+
+func TestCollectorMissingDevices(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UAPs: []*unifi.UAP{
+			{Name: "uap-1", SiteName: "default", State: *unifi.NewFlexInt(stateConnected)},
+			{Name: "uap-2", SiteName: "default", State: *unifi.NewFlexInt(stateConnected)},
+		}},
+	}
+
+	UniFiKeepMissingDevices = true
+	defer func() { UniFiKeepMissingDevices = false }()
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_device_state"))
+
+	// The controller forgets uap-2
+	mc.Devices = &unifi.Devices{UAPs: mc.Devices.UAPs[:1]}
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_device_state Whether the device is connected to the controller (1) or not (0)
+# TYPE unifi_device_state gauge
+unifi_device_state{name="uap-1",site="default",type="UAP"} 1
+unifi_device_state{name="uap-2",site="default",type="UAP"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_state"))
+}
+
+func TestCollectorDuplicateCounters(t *testing.T) {
+	// Two unnamed switches without an IP end up with the same labels
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{
+			{Stat: unifi.USWStat{Sw: &unifi.Sw{RxBytes: *unifi.NewFlexInt(100)}}},
+			{Stat: unifi.USWStat{Sw: &unifi.Sw{RxBytes: *unifi.NewFlexInt(50)}}},
+		}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_switch_rx_bytes_total Switch RX bytes
+# TYPE unifi_switch_rx_bytes_total counter
+unifi_switch_rx_bytes_total{name="",site="",source="",type="USW"} 150
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_switch_rx_bytes_total"))
+}
+
+func TestCollectorLoadAverage(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{{Name: "udm-pro", SiteName: "default", IP: "192.168.1.1", SysStats: unifi.SysStats{
+				Loadavg1: *unifi.NewFlexInt(3), Loadavg5: *unifi.NewFlexInt(2), Loadavg15: *unifi.NewFlexInt(1),
+			}}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "unifi_device_load1", "unifi_device_load5", "unifi_device_load15"))
+	assert.Equal(t, 3.0, testutil.ToFloat64(col.deviceLoad1.WithLabelValues("UDM", "default", "192.168.1.1", "udm-pro")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.deviceLoad5.WithLabelValues("UDM", "default", "192.168.1.1", "udm-pro")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.deviceLoad15.WithLabelValues("UDM", "default", "192.168.1.1", "udm-pro")))
+}
+
+func TestCollectorUDMStorage(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{{Name: "udm-pro", SiteName: "default", Storage: []*unifi.Storage{
+				{Name: "Hard disk", MountPoint: "/volume1", Size: *unifi.NewFlexInt(3.9e12), Used: *unifi.NewFlexInt(3.5e12)},
+				nil,
+			}}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_udm_storage_used_bytes", "unifi_udm_storage_total_bytes"))
+	assert.Equal(t, 3.5e12, testutil.ToFloat64(col.udmStorageUsed.WithLabelValues("default", "udm-pro", "Hard disk", "/volume1")))
+	assert.Equal(t, 3.9e12, testutil.ToFloat64(col.udmStorageTotal.WithLabelValues("default", "udm-pro", "Hard disk", "/volume1")))
+}
+
+func TestCollectorSSIDChannelWidth(t *testing.T) {
+	uap := &unifi.UAP{
+		Name:             "uap-1",
+		Mac:              "aa:bb:cc:dd:ee:ff",
+		BandsteeringMode: "prefer_5g",
+		RadioTable: unifi.RadioTable{
+			{Radio: "ng", Ht: *unifi.NewFlexInt(20)},
+			{Radio: "na", Ht: *unifi.NewFlexInt(80)},
+		},
+	}
+	uap.VapTable = make(unifi.VapTable, 3)
+	uap.VapTable[0].Essid, uap.VapTable[0].Radio, uap.VapTable[0].NumSta = "home", "ng", 2
+	uap.VapTable[1].Essid, uap.VapTable[1].Radio, uap.VapTable[1].NumSta = "home", "na", 5
+	// No radio_table entry for 6GHz, so no channel width is exported
+	uap.VapTable[2].Essid, uap.VapTable[2].Radio, uap.VapTable[2].NumSta = "home", "6e", 1
+
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UAPs: []*unifi.UAP{uap}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	testutil.CollectAndCount(col)
+
+	assert.Equal(t, 20.0, testutil.ToFloat64(col.ssidChannelWidth.WithLabelValues("home", "aa:bb:cc:dd:ee:ff", "ng")))
+	assert.Equal(t, 80.0, testutil.ToFloat64(col.ssidChannelWidth.WithLabelValues("home", "aa:bb:cc:dd:ee:ff", "na")))
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_ap_ssid_channel_width_mhz"))
+	assert.Equal(t, 5.0, testutil.ToFloat64(col.bandSteeringClients.WithLabelValues("home", "aa:bb:cc:dd:ee:ff", "na")))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_ap_band_steering_clients"))
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "unifi_ssid_clients"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.ssidClients.WithLabelValues("", "uap-1", "", "home", "ng")))
+}
+
+func TestCollectorDeviceTemperatures(t *testing.T) {
+	udm := &unifi.UDM{Name: "udm-pro", IP: "192.168.1.1", Model: "UDMPRO", Temperatures: []unifi.Temperature{
+		{Name: "CPU", Type: "cpu", Value: 62},
+		{Name: "Local", Type: "board", Value: 48},
+	}}
+	usw := &unifi.USW{Name: "usw-1", IP: "192.168.1.3", Model: "US24P250", Stat: unifi.USWStat{Sw: &unifi.Sw{}},
+		HasTemperature: unifi.FlexBool{Val: true, Txt: "true"}, GeneralTemperature: *unifi.NewFlexInt(41)}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UDMs: []*unifi.UDM{udm}, USWs: []*unifi.USW{usw}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	// type is the kind of device and model the hardware, as on every other
+	// device metric
+	expected := `
+# HELP unifi_device_temperature_celsius Device temp (°C)
+# TYPE unifi_device_temperature_celsius gauge
+unifi_device_temperature_celsius{model="UDMPRO",name="udm-pro",sensor="CPU",site="",source="192.168.1.1",type="UDM"} 62
+unifi_device_temperature_celsius{model="UDMPRO",name="udm-pro",sensor="Local",site="",source="192.168.1.1",type="UDM"} 48
+unifi_device_temperature_celsius{model="US24P250",name="usw-1",sensor="general",site="",source="192.168.1.3",type="USW"} 41
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_temperature_celsius"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.deviceCPU.WithLabelValues("UDM", "", "192.168.1.1", "udm-pro", "UDMPRO")))
+}
+
+func TestCollectorSFP(t *testing.T) {
+	usw := &unifi.USW{Name: "usw-agg", IP: "192.168.1.2", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{{
+		Name:           "SFP+ 1",
+		PortIdx:        *unifi.NewFlexInt(9),
+		SFPFound:       unifi.FlexBool{Val: true, Txt: "true"},
+		SFPTemperature: *unifi.NewFlexInt(41),
+		SFPRxpower:     *unifi.NewFlexInt(-5.2),
+		SFPTxpower:     *unifi.NewFlexInt(-2.4),
+		SFPVoltage:     *unifi.NewFlexInt(3.3),
+	}, {
+		// Copper port without a transceiver
+		Name:    "Port 1",
+		PortIdx: *unifi.NewFlexInt(1),
+	}}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	labels := []string{"USW", "", "192.168.1.2", "usw-agg", "SFP+ 1", "9", ""}
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "unifi_port_sfp_rx_power_dbm", "unifi_port_sfp_tx_power_dbm", "unifi_port_sfp_voltage_volts"))
+	assert.Equal(t, -5.2, testutil.ToFloat64(col.pSFPRx.WithLabelValues(labels...)))
+	assert.Equal(t, -2.4, testutil.ToFloat64(col.pSFPTx.WithLabelValues(labels...)))
+	assert.Equal(t, 3.3, testutil.ToFloat64(col.pSFPVolt.WithLabelValues(labels...)))
+}
+
+func TestCollectorPortUp(t *testing.T) {
+	udm := &unifi.UDM{Name: "udm", IP: "192.168.1.1"}
+	udm.PortTable = []unifi.Port{
+		{Name: "Port 1", PortIdx: *unifi.NewFlexInt(1), Up: unifi.FlexBool{Val: true, Txt: "true"}},
+		{Name: "WAN", PortIdx: *unifi.NewFlexInt(9), IsUplink: unifi.FlexBool{Val: true, Txt: "true"}},
+	}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UDMs: []*unifi.UDM{udm}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_port_up Whether the port link is up (1) or not (0)
+# TYPE unifi_port_up gauge
+unifi_port_up{name="udm",port="Port 1",port_number="1",site="",source="192.168.1.1",type="UDM",uplink=""} 1
+unifi_port_up{name="udm",port="WAN",port_number="9",site="",source="192.168.1.1",type="UDM",uplink="true"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_port_up"))
+}
+
+func TestCollectorPortSTPState(t *testing.T) {
+	usw := &unifi.USW{Name: "usw-1", IP: "192.168.1.3", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{
+		{Name: "Port 1", PortIdx: *unifi.NewFlexInt(1), StpState: "forwarding"},
+		// Blocked by STP after a loop was patched in
+		{Name: "Port 2", PortIdx: *unifi.NewFlexInt(2), StpState: "blocking"},
+		{Name: "Port 3", PortIdx: *unifi.NewFlexInt(3)},
+	}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_port_stp_state Spanning tree state of the port: 1 disabled, 2 blocking, 3 listening, 4 learning, 5 forwarding, 6 broken
+# TYPE unifi_port_stp_state gauge
+unifi_port_stp_state{name="usw-1",port="Port 1",port_number="1",site="",source="192.168.1.3",type="USW",uplink=""} 5
+unifi_port_stp_state{name="usw-1",port="Port 2",port_number="2",site="",source="192.168.1.3",type="USW",uplink=""} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_port_stp_state"))
+}
+
+func TestCollectorMinimalPortLabels(t *testing.T) {
+	usw := &unifi.USW{Name: "usw-1", IP: "192.168.1.3", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{
+		{Name: "Port 1", PortIdx: *unifi.NewFlexInt(1), Up: unifi.FlexBool{Val: true, Txt: "true"}, RxBytes: *unifi.NewFlexInt(100)},
+		{Name: "Port 2", PortIdx: *unifi.NewFlexInt(2)},
+	}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw}},
+	}
+
+	UniFiMinimalPortLabels = true
+	defer func() { UniFiMinimalPortLabels = false }()
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_port_rx_bytes_total Port RX bytes
+# TYPE unifi_port_rx_bytes_total counter
+unifi_port_rx_bytes_total{name="usw-1",port="Port 1",port_number="1",site="",type="USW"} 100
+unifi_port_rx_bytes_total{name="usw-1",port="Port 2",port_number="2",site="",type="USW"} 0
+# HELP unifi_port_up Whether the port link is up (1) or not (0)
+# TYPE unifi_port_up gauge
+unifi_port_up{name="usw-1",port="Port 1",port_number="1",site="",type="USW"} 1
+unifi_port_up{name="usw-1",port="Port 2",port_number="2",site="",type="USW"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_port_rx_bytes_total", "unifi_port_up"))
+}
+
+func TestCollectorPoE(t *testing.T) {
+	usw := &unifi.USW{Name: "usw-1", IP: "192.168.1.3", Mac: "aa:bb:cc:00:00:01", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{{
+		Name:      "Port 1",
+		PortIdx:   *unifi.NewFlexInt(1),
+		PoeEnable: unifi.FlexBool{Val: true, Txt: "true"},
+		PoeMode:   "auto",
+		PoePower:  *unifi.NewFlexInt(15),
+	}, {
+		Name:    "Port 2",
+		PortIdx: *unifi.NewFlexInt(2),
+	}}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	col.now = func() time.Time { return now }
+	labels := []string{"USW", "", "192.168.1.3", "usw-1", "Port 1", "1", ""}
+
+	energy := `
+# HELP unifi_port_poe_energy_kwh Port PoE energy integrated from power readings since exporter start (kWh)
+# TYPE unifi_port_poe_energy_kwh counter
+unifi_port_poe_energy_kwh{name="usw-1",port="Port 1",port_number="1",site="",source="192.168.1.3",type="USW",uplink=""} %v
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(energy, 0)), "unifi_port_poe_energy_kwh"))
+	assert.Equal(t, 15.0, testutil.ToFloat64(col.pPoEPower.WithLabelValues(append(labels, "auto")...)))
+
+	// 15 W for two hours is 0.03 kWh
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(energy, 0.03)), "unifi_port_poe_energy_kwh"))
+
+	// PoE is off for a scrape, so the port's meter is dropped
+	usw.PortTable[0].PoeEnable = unifi.FlexBool{Val: false, Txt: "false"}
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_port_poe_energy_kwh"))
+	assert.Empty(t, col.poeEnergy)
+
+	// Once back, the meter starts over instead of integrating the gap
+	usw.PortTable[0].PoeEnable = unifi.FlexBool{Val: true, Txt: "true"}
+	now = now.Add(5 * time.Hour)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(energy, 0)), "unifi_port_poe_energy_kwh"))
+	now = now.Add(time.Hour)
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(fmt.Sprintf(energy, 0.015)), "unifi_port_poe_energy_kwh"))
+}
+
+func TestCollectorSwitchPoE(t *testing.T) {
+	usw := &unifi.USW{Name: "usw-poe", IP: "192.168.1.4", TotalMaxPower: *unifi.NewFlexInt(120), Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	usw.PortTable = []unifi.Port{
+		{Name: "Port 1", PortIdx: *unifi.NewFlexInt(1), PoeEnable: unifi.FlexBool{Val: true, Txt: "true"}, PoePower: *unifi.NewFlexInt(12.5)},
+		{Name: "Port 2", PortIdx: *unifi.NewFlexInt(2), PoeEnable: unifi.FlexBool{Val: true, Txt: "true"}, PoePower: *unifi.NewFlexInt(6)},
+		{Name: "Port 3", PortIdx: *unifi.NewFlexInt(3)},
+	}
+	// A switch without PoE reports no budget
+	plain := &unifi.USW{Name: "usw-plain", IP: "192.168.1.5", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{USWs: []*unifi.USW{usw, plain}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_switch_poe_budget_watts", "unifi_switch_poe_used_watts"))
+	assert.Equal(t, 120.0, testutil.ToFloat64(col.swPoEBudget.WithLabelValues("", "usw-poe")))
+	assert.Equal(t, 18.5, testutil.ToFloat64(col.swPoEUsed.WithLabelValues("", "usw-poe")))
+}
+
+func TestCollectorUplink(t *testing.T) {
+	// An AP that negotiated down to 100 Mbps behind a switch at the root
+	uap := &unifi.UAP{Name: "uap-1", SiteName: "default"}
+	uap.Uplink.UplinkMac = "aa:bb:cc:00:00:01"
+	uap.Uplink.Speed = *unifi.NewFlexInt(100)
+	uap.Uplink.FullDuplex = unifi.FlexBool{Val: true, Txt: "true"}
+	uap.Uplink.Up = unifi.FlexBool{Val: true, Txt: "true"}
+	root := &unifi.USW{Name: "usw-root", SiteName: "default", Mac: "aa:bb:cc:00:00:01", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UAPs: []*unifi.UAP{uap}, USWs: []*unifi.USW{root}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_device_uplink_speed_mbps Negotiated speed of the link to the upstream device (Mbps)
+# TYPE unifi_device_uplink_speed_mbps gauge
+unifi_device_uplink_speed_mbps{full_duplex="true",name="uap-1",site="default",uplink_mac="aa:bb:cc:00:00:01"} 100
+# HELP unifi_device_uplink_up Whether the link to the upstream device is up (1) or not (0)
+# TYPE unifi_device_uplink_up gauge
+unifi_device_uplink_up{name="uap-1",site="default",uplink_mac="aa:bb:cc:00:00:01"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_uplink_speed_mbps", "unifi_device_uplink_up"))
+}
+
+func TestCollectorDeviceClients(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UAPs: []*unifi.UAP{{Name: "uap-1", SiteName: "default", Mac: "aa:bb:cc:00:00:01"}},
+			USWs: []*unifi.USW{{Name: "usw-1", SiteName: "default", Mac: "aa:bb:cc:00:00:02", Stat: unifi.USWStat{Sw: &unifi.Sw{}}}},
+		},
+		Clients: []*unifi.Client{
+			// Wireless clients also report the switch port of their AP
+			{SiteName: "default", Name: "phone", Mac: "11:11", ApMac: "AA:BB:CC:00:00:01", SwMac: "aa:bb:cc:00:00:02"},
+			{SiteName: "default", Name: "laptop", Mac: "22:22", ApMac: "aa:bb:cc:00:00:01"},
+			{SiteName: "default", Name: "nas", Mac: "33:33", SwMac: "aa:bb:cc:00:00:02", IsWired: unifi.FlexBool{Val: true, Txt: "true"}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_device_connected_clients Clients connected through the device, wireless ones by AP and wired ones by switch
+# TYPE unifi_device_connected_clients gauge
+unifi_device_connected_clients{mac="aa:bb:cc:00:00:01",name="uap-1",site="default",type="UAP"} 2
+unifi_device_connected_clients{mac="aa:bb:cc:00:00:02",name="usw-1",site="default",type="USW"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_connected_clients"))
+}
+
+func TestCollectorClientRssi(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{
+			{SiteName: "default", Name: "phone", Mac: "11:11", Network: "Trusted", ApMac: "aa:bb", Essid: "home", Rssi: *unifi.NewFlexInt(-61)},
+			{SiteName: "default", Name: "nas", Mac: "22:22", IsWired: unifi.FlexBool{Val: true, Txt: "true"}},
+			{SiteName: "default", Name: "unknown", Mac: "33:33", ApMac: "aa:bb", Essid: "home"},
+		},
+		Devices: &unifi.Devices{},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_client_rssi_dbm"))
+	assert.Equal(t, -61.0, testutil.ToFloat64(col.clientRssi.WithLabelValues("default", "phone", "11:11", "Trusted", "aa:bb", "home")))
+}
+
+func TestCollectorClientSatisfaction(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{
+			{SiteName: "default", Name: "laptop", Mac: "11:11", ApMac: "aa:bb", Satisfaction: *unifi.NewFlexInt(87)},
+			{SiteName: "default", Name: "new-phone", Mac: "22:22", ApMac: "aa:bb", Satisfaction: *unifi.NewFlexInt(-1)},
+			{SiteName: "default", Name: "camera", Mac: "33:33", ApMac: "aa:bb"},
+			{SiteName: "default", Name: "nas", Mac: "44:44", IsWired: unifi.FlexBool{Val: true, Txt: "true"}, Satisfaction: *unifi.NewFlexInt(100)},
+		},
+		Devices: &unifi.Devices{},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_client_satisfaction_pct"))
+	assert.Equal(t, 87.0, testutil.ToFloat64(col.clientSatisfaction.WithLabelValues("default", "laptop", "11:11", "", "aa:bb")))
+}
+
+func TestCollectorClientUptime(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{
+			{SiteName: "default", Name: "plug", Mac: "11:11", Network: "IoT", Uptime: *unifi.NewFlexInt(42), LastSeen: *unifi.NewFlexInt(1700000000)},
+			{SiteName: "default", Name: "nas", Mac: "22:22", Network: "LAN", IsWired: unifi.FlexBool{Val: true, Txt: "true"}, Uptime: *unifi.NewFlexInt(86400)},
+		},
+		Devices: &unifi.Devices{},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_client_uptime_seconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_client_last_seen_timestamp_seconds"))
+	assert.Equal(t, 42.0, testutil.ToFloat64(col.clientUptime.WithLabelValues("default", "plug", "11:11", "IoT")))
+	assert.Equal(t, 86400.0, testutil.ToFloat64(col.clientUptime.WithLabelValues("default", "nas", "22:22", "LAN")))
+	assert.Equal(t, 1700000000.0, testutil.ToFloat64(col.clientLastSeen.WithLabelValues("default", "plug", "11:11", "IoT")))
+}
+
+func TestCollectorDPI(t *testing.T) {
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{{SiteName: "default", Name: "tv", Mac: "11:11"}},
+		Devices: &unifi.Devices{},
+		DPI: []*unifi.DPITable{{
+			SiteName: "default",
+			MAC:      "11:11",
+			// A known application, and one the controller has no name for
+			ByApp: []unifi.DPIData{
+				{Cat: *unifi.NewFlexInt(4), App: *unifi.NewFlexInt(2), TxBytes: *unifi.NewFlexInt(1000), RxBytes: *unifi.NewFlexInt(90000)},
+				{Cat: *unifi.NewFlexInt(255), App: *unifi.NewFlexInt(65535), TxBytes: *unifi.NewFlexInt(10), RxBytes: *unifi.NewFlexInt(20)},
+			},
+		}, {
+			SiteName: "default",
+			MAC:      "22:22",
+			ByApp:    []unifi.DPIData{{Cat: *unifi.NewFlexInt(4), App: *unifi.NewFlexInt(2), TxBytes: *unifi.NewFlexInt(5), RxBytes: *unifi.NewFlexInt(7)}},
+		}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	// Off by default
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_dpi_tx_bytes_total", "unifi_dpi_rx_bytes_total"))
+
+	col.dpi = true
+	assert.NoError(t, col.fetch(context.Background()))
+	assert.Equal(t, 6, testutil.CollectAndCount(col, "unifi_dpi_tx_bytes_total", "unifi_dpi_rx_bytes_total"))
+
+	expected := `
+# HELP unifi_dpi_rx_bytes_total Client RX bytes per DPI application
+# TYPE unifi_dpi_rx_bytes_total counter
+unifi_dpi_rx_bytes_total{app="RealPlayer",category="Media Streaming",client="22:22",site="default"} 7
+unifi_dpi_rx_bytes_total{app="RealPlayer",category="Media Streaming",client="tv",site="default"} 90000
+unifi_dpi_rx_bytes_total{app="Unknown_Other",category="Unknown_255",client="tv",site="default"} 20
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_dpi_rx_bytes_total"))
+}
+
+func TestCollectorIDSAlarms(t *testing.T) {
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{},
+		Alarms: []*unifi.Alarm{
+			{SiteName: "default", Host: "udm-pro", Key: "EVT_IPS_IpsAlert", InnerAlertCategory: "Attempted Information Leak"},
+			{SiteName: "default", Host: "udm-pro", Key: "EVT_IPS_IpsAlert", InnerAlertCategory: "Attempted Information Leak"},
+			{SiteName: "default", Host: "udm-pro", Key: "EVT_IPS_IpsAlert", InnerAlertCategory: "Misc Attack"},
+			// Not raised by IDS/IPS
+			{SiteName: "default", Key: "EVT_AP_Lost_Contact"},
+			nil,
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+	// Off by default
+	assert.Equal(t, 0, testutil.CollectAndCount(col, "unifi_ids_alarms"))
+
+	col.ids = true
+	assert.NoError(t, col.fetch(context.Background()))
+	expected := `
+# HELP unifi_ids_alarms Threat alarms raised by IDS/IPS that the controller still lists
+# TYPE unifi_ids_alarms gauge
+unifi_ids_alarms{category="Attempted Information Leak",name="udm-pro",site="default"} 2
+unifi_ids_alarms{category="Misc Attack",name="udm-pro",site="default"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_ids_alarms"))
+
+	// Archived alarms are no longer listed, so the count goes down
+	mc.Alarms = mc.Alarms[2:]
+	assert.NoError(t, col.fetch(context.Background()))
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_ids_alarms"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.idsAlarms.WithLabelValues("default", "udm-pro", "Misc Attack")))
+}
+
+func TestCollectorClientTraffic(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{
+			{SiteName: "default", Name: "nas", Mac: "22:22", Network: "LAN", TxBytes: *unifi.NewFlexInt(1000), RxBytes: *unifi.NewFlexInt(2000)},
+		},
+		Devices: &unifi.Devices{},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_client_rx_bytes_total Client RX bytes
+# TYPE unifi_client_rx_bytes_total counter
+unifi_client_rx_bytes_total{mac="22:22",name="nas",network="LAN",site="default"} 2000
+# HELP unifi_client_tx_bytes_total Client TX bytes
+# TYPE unifi_client_tx_bytes_total counter
+unifi_client_tx_bytes_total{mac="22:22",name="nas",network="LAN",site="default"} 1000
+`
+	// Repeated scrapes report the controller's totals rather than resetting
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_client_tx_bytes_total", "unifi_client_rx_bytes_total"))
+	}
+}
+
+func TestCollectorMultipleDevices(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{
+				{Name: "udm-1", IP: "192.168.1.1", SystemStats: unifi.SystemStats{CPU: *unifi.NewFlexInt(15)}},
+				{Name: "udm-2", IP: "192.168.2.1", SystemStats: unifi.SystemStats{CPU: *unifi.NewFlexInt(40)}},
+			},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_device_cpu_pct"))
+	assert.Equal(t, 15.0, testutil.ToFloat64(col.deviceCPU.WithLabelValues("UDM", "", "192.168.1.1", "udm-1", "")))
+	assert.Equal(t, 40.0, testutil.ToFloat64(col.deviceCPU.WithLabelValues("UDM", "", "192.168.2.1", "udm-2", "")))
+}
+
+func TestCollectorUp(t *testing.T) {
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{},
+	}
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.runner.scrape())
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.up))
+	assert.True(t, col.Ready())
+
+	failing := &mockClient{
+		Sites:      []*unifi.Site{{Name: "default", ID: "site-id"}},
+		DevicesErr: errors.New("controller unavailable"),
+	}
+	col = newUniFiCollectorWithClient(failing)
+	err := col.runner.scrape()
+	assert.ErrorIs(t, err, failing.DevicesErr)
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.up))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(col.scrapeErrors), 1.0)
+	assert.False(t, col.Ready())
+}
+
+func TestCollectorPartialFailure(t *testing.T) {
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Clients: []*unifi.Client{{SiteName: "default", Name: "phone", Mac: "11:11"}},
+		Devices: &unifi.Devices{UAPs: []*unifi.UAP{{Name: "uap-1", SiteName: "default"}}},
+	}
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	// The devices keep their previous cache while the clients update
+	mc.DevicesErr = errors.New("controller unavailable")
+	mc.Clients = append(mc.Clients, &unifi.Client{SiteName: "default", Name: "laptop", Mac: "22:22"})
+	assert.ErrorIs(t, col.fetch(context.Background()), mc.DevicesErr)
+	assert.Len(t, col.cache.Clients, 2)
+	assert.Len(t, col.cache.Devices.UAPs, 1)
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_device_info"))
+}
+
+func TestCollectorNilDevices(t *testing.T) {
+	someErr := errors.New("controller unavailable")
+	for _, mc := range []*mockClient{
+		{Sites: []*unifi.Site{{Name: "default", ID: "site-id"}}, DevicesErr: someErr},
+		// No error, but no devices either
+		{Sites: []*unifi.Site{{Name: "default", ID: "site-id"}}},
+	} {
+		col := newUniFiCollectorWithClient(mc)
+		assert.NotPanics(t, func() { assert.Error(t, col.fetch(context.Background())) })
+		assert.NotPanics(t, func() { testutil.CollectAndCount(col) })
+	}
+}
+
+func TestCollectorDeviceInfo(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UAPs: []*unifi.UAP{{Name: "uap-1", SiteName: "default", Model: "U7PG2", Version: "6.6.77", Mac: "aa:bb:cc:dd:ee:ff", Uptime: *unifi.NewFlexInt(86400)}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_device_info"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.deviceInfo.WithLabelValues("UAP", "default", "uap-1", "U7PG2", "6.6.77", "aa:bb:cc:dd:ee:ff")))
+	assert.Equal(t, 86400.0, testutil.ToFloat64(col.deviceUptime.WithLabelValues("UAP", "default", "", "uap-1")))
+}
+
+func TestCollectorRadios(t *testing.T) {
+	uap := &unifi.UAP{Name: "uap-1", SiteName: "default"}
+	uap.RadioTableStats = make(unifi.RadioTableStats, 2)
+	uap.RadioTableStats[0].Name, uap.RadioTableStats[0].Radio = "wifi0", "ng"
+	uap.RadioTableStats[0].Channel, uap.RadioTableStats[0].TxPower, uap.RadioTableStats[0].CuTotal = *unifi.NewFlexInt(6), *unifi.NewFlexInt(17), *unifi.NewFlexInt(48)
+	uap.RadioTableStats[1].Name, uap.RadioTableStats[1].Radio = "wifi1", "na"
+	uap.RadioTableStats[1].Channel, uap.RadioTableStats[1].TxPower, uap.RadioTableStats[1].CuTotal = *unifi.NewFlexInt(36), *unifi.NewFlexInt(23), *unifi.NewFlexInt(12)
+	uap.RadioTableStats[1].NumSta, uap.RadioTableStats[1].TxPackets, uap.RadioTableStats[1].TxRetries = *unifi.NewFlexInt(9), *unifi.NewFlexInt(2000), *unifi.NewFlexInt(150)
+
+	mc := &mockClient{
+		Sites:   []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{UAPs: []*unifi.UAP{uap}},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(col, "unifi_radio_channel"))
+	assert.Equal(t, 36.0, testutil.ToFloat64(col.radioChannel.WithLabelValues("default", "uap-1", "na", "wifi1")))
+	assert.Equal(t, 17.0, testutil.ToFloat64(col.radioTxPower.WithLabelValues("default", "uap-1", "ng", "wifi0")))
+	assert.Equal(t, 48.0, testutil.ToFloat64(col.radioUtilization.WithLabelValues("default", "uap-1", "ng", "wifi0")))
+	assert.Equal(t, 9.0, testutil.ToFloat64(col.radioClients.WithLabelValues("default", "uap-1", "na", "wifi1")))
+	// wifi0 sent nothing, so it has no retry rate
+	assert.Equal(t, 1, testutil.CollectAndCount(col, "unifi_radio_tx_retries_pct"))
+	assert.Equal(t, 7.5, testutil.ToFloat64(col.radioTxRetries.WithLabelValues("default", "uap-1", "na", "wifi1")))
+}
+
+func TestCollectorWAN(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			USGs: []*unifi.USG{{
+				Name:     "usg-1",
+				SiteName: "default",
+				Wan1: unifi.Wan{
+					Ifname:  "eth0",
+					IP:      "203.0.113.7",
+					RxBytes: *unifi.NewFlexInt(5000),
+					TxBytes: *unifi.NewFlexInt(3000),
+					BytesR:  *unifi.NewFlexInt(1200),
+				},
+				// Wan2 is not configured
+			}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	wanLabels := []string{"default", "usg-1", "wan1", "203.0.113.7"}
+	expected := `
+# HELP unifi_wan_rx_bytes_total WAN RX bytes
+# TYPE unifi_wan_rx_bytes_total counter
+unifi_wan_rx_bytes_total{ip="203.0.113.7",name="usg-1",site="default",wan="wan1"} 5000
+# HELP unifi_wan_tx_bytes_total WAN TX bytes
+# TYPE unifi_wan_tx_bytes_total counter
+unifi_wan_tx_bytes_total{ip="203.0.113.7",name="usg-1",site="default",wan="wan1"} 3000
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_wan_rx_bytes_total", "unifi_wan_tx_bytes_total"))
+	assert.Equal(t, 1200.0, testutil.ToFloat64(col.wanRate.WithLabelValues(wanLabels...)))
+}
+
+func TestCollectorWANStatus(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{{
+				Name:     "udm-1",
+				SiteName: "default",
+				Wan1:     unifi.Wan{Ifname: "eth8", IP: "203.0.113.7"},
+				Wan2:     unifi.Wan{Ifname: "eth9", IP: "198.51.100.4"},
+				// wan1 is the active uplink
+				Uplink:  unifi.Uplink{Name: "eth8", Uptime: *unifi.NewFlexInt(86400)},
+				GeoInfo: map[string]unifi.GeoInfo{"WAN": {IspName: "Fiber Co"}},
+			}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	expected := `
+# HELP unifi_wan_info WAN address and the ISP detected for it, always 1
+# TYPE unifi_wan_info gauge
+unifi_wan_info{ip="198.51.100.4",isp="",name="udm-1",site="default",wan="wan2"} 1
+unifi_wan_info{ip="203.0.113.7",isp="Fiber Co",name="udm-1",site="default",wan="wan1"} 1
+# HELP unifi_wan_uptime_seconds Time since the active WAN connection came up (s)
+# TYPE unifi_wan_uptime_seconds gauge
+unifi_wan_uptime_seconds{name="udm-1",site="default",wan="wan1"} 86400
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_wan_info", "unifi_wan_uptime_seconds"))
+}
+
+func TestCollectorSpeedtest(t *testing.T) {
+	mc := &mockClient{
+		Sites: []*unifi.Site{{Name: "default", ID: "site-id"}},
+		Devices: &unifi.Devices{
+			UDMs: []*unifi.UDM{{
+				Name:     "udm-1",
+				SiteName: "default",
+				SpeedtestStatus: unifi.SpeedtestStatus{
+					Rundate:      *unifi.NewFlexInt(1735689600),
+					XputDownload: *unifi.NewFlexInt(942.5),
+					XputUpload:   *unifi.NewFlexInt(48.1),
+					Latency:      *unifi.NewFlexInt(9),
+				},
+			}},
+			// Never ran a speed test
+			USGs: []*unifi.USG{{Name: "usg-1", SiteName: "default"}},
+		},
+	}
+
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(col, "unifi_speedtest_download_bps", "unifi_speedtest_upload_bps", "unifi_speedtest_latency_ms"))
+	assert.Equal(t, 942.5e6, testutil.ToFloat64(col.speedtestDown.WithLabelValues("default", "udm-1")))
+	assert.Equal(t, 48.1e6, testutil.ToFloat64(col.speedtestUp.WithLabelValues("default", "udm-1")))
+	assert.Equal(t, 9.0, testutil.ToFloat64(col.speedtestLatency.WithLabelValues("default", "udm-1")))
+}
+
+// flakyLoginClient rejects the first failures login attempts, as a
+// restarting controller would.
+type flakyLoginClient struct {
+	mockClient
+	failures int
+	logins   int
+}
+
+func (f *flakyLoginClient) Login() error {
+	f.logins++
+	if f.logins <= f.failures {
+		return errors.New("controller restarting")
+	}
+	return nil
+}
+
+func TestCollectorLoginRetry(t *testing.T) {
+	// Built directly so that no background fetch shares the client
+	newCollector := func(client UniFiClient) *UniFiCollector {
+		return &UniFiCollector{client: client, loginAttempts: 3, loginBackoff: time.Millisecond}
+	}
+
+	fc := &flakyLoginClient{failures: 2}
+	assert.NoError(t, newCollector(fc).login(context.Background()))
+	assert.Equal(t, 3, fc.logins)
+
+	fc = &flakyLoginClient{failures: 5}
+	assert.Error(t, newCollector(fc).login(context.Background()))
+	assert.Equal(t, 3, fc.logins)
+}
+
+func TestFilterSites(t *testing.T) {
+	sites := []*unifi.Site{
+		{Name: "default", Desc: "Default"},
+		{Name: "x7k2p9", Desc: "Cabin"},
+		{Name: "q4m8n1", Desc: "Office"},
+	}
+
+	assert.Len(t, filterSites(sites, nil), 3)
+	filtered := filterSites(sites, siteSet([]string{"default", "Cabin"}))
+	assert.Len(t, filtered, 2)
+	assert.Equal(t, "default", filtered[0].Name)
+	assert.Equal(t, "x7k2p9", filtered[1].Name)
+	assert.Empty(t, filterSites(sites, siteSet([]string{"missing"})))
+}
+
+func TestCollectorSites(t *testing.T) {
+	// Health is an anonymous struct slice, so the site is decoded from JSON
+	site := &unifi.Site{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"name": "default", "desc": "Default",
+		"health": [
+			{"subsystem": "wan", "status": "error"},
+			{"subsystem": "wlan", "status": "ok", "num_disconnected": 1}
+		]
+	}`), site))
+	site.SiteName = "Default (default)"
+
+	mc := &mockClient{
+		Sites: []*unifi.Site{site},
+		Devices: &unifi.Devices{
+			UAPs: []*unifi.UAP{{Name: "uap-1", SiteName: site.SiteName}, {Name: "uap-2", SiteName: site.SiteName}},
+		},
+		Clients: []*unifi.Client{{Name: "laptop", SiteName: site.SiteName}},
+	}
+	col := newUniFiCollectorWithClient(mc)
+	assert.NoError(t, col.fetch(context.Background()))
+
+	assert.Equal(t, 4, testutil.CollectAndCount(col, "unifi_site_devices", "unifi_site_clients", "unifi_site_wan_status", "unifi_site_devices_disconnected"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(col.siteDevices.WithLabelValues("Default (default)", "Default")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.siteClients.WithLabelValues("Default (default)", "Default")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(col.siteWANStatus.WithLabelValues("Default (default)", "Default")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(col.siteDisconnected.WithLabelValues("Default (default)", "Default")))
+
+	// The site summary joins the device metrics on the site label
+	expected := `
+# HELP unifi_device_state Whether the device is connected to the controller (1) or not (0)
+# TYPE unifi_device_state gauge
+unifi_device_state{name="uap-1",site="Default (default)",type="UAP"} 0
+unifi_device_state{name="uap-2",site="Default (default)",type="UAP"} 0
+# HELP unifi_site_devices Devices of the site
+# TYPE unifi_site_devices gauge
+unifi_site_devices{desc="Default",site="Default (default)"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(col, strings.NewReader(expected), "unifi_device_state", "unifi_site_devices"))
 }